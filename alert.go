@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+)
+
+// An alert is how alarmed a guard is. A guard patrols until it sees a player, grows suspicious,
+// and gives chase if it keeps seeing them for cfg.SuspicionTime, less on an alert map (see radio).
+// Once it loses them it investigates where they were last seen for cfg.InvestigateTime, then
+// returns to its patrol.
+type alert byte
+
+const (
+	alertPatrol alert = iota
+	alertSuspicious
+	alertChase
+	alertInvestigate
+	alertReturn
+)
+
+var alertNames = []string{"patrol", "suspicious", "chase", "investigate", "return"}
+
+func (a alert) String() string {
+	if int(a) < len(alertNames) {
+		return alertNames[a]
+	}
+	return fmt.Sprint("alert", byte(a))
+}
+
+func (a alert) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *alert) UnmarshalText(text []byte) error {
+	i := slices.Index(alertNames, string(text))
+	if i < 0 {
+		return fmt.Errorf("unknown alert %q", text)
+	}
+	*a = alert(i)
+	return nil
+}
+
+// An investigating guard first goes to where it lost the player, then walks a ring of this many
+// points this far around it, starting straight ahead
+const searchPoints = 6
+const searchRadius = 120
+
+// searchPoint is the sweep'th place an investigating guard looks, after the last-known position
+func searchPoint(g *guard) state {
+	if g.sweep%(searchPoints+1) == 0 {
+		return g.lastKnown
+	}
+	angle := g.searchHeading + float64(g.sweep%(searchPoints+1)-1)*2*math.Pi/searchPoints
+	return state{
+		x: g.lastKnown.x + searchRadius*float32(math.Cos(angle)),
+		y: g.lastKnown.y + searchRadius*float32(math.Sin(angle)),
+	}
+}
+
+// setAlert puts g in a new alert state as of this tick. Whatever it was planning no longer applies.
+func (h *Hub) setAlert(g *guard, a alert) {
+	g.Alert = a
+	g.alertedAt = h.tick
+	g.actions = g.actions[:0]
+	g.route = nil // a patrol route is no use for a chase, nor a chase route for a search
+	g.changeMind()
+}
+
+// detectPlayers looks through every guard's eyes and moves it on to its next alert state
+func (h *Hub) detectPlayers(m model) {
+	for i := range h.guards {
+		g := &h.guards[i]
+		seen := h.spot(g, m)
+		if seen != nil {
+			g.lastKnown = state{x: seen.X, y: seen.Y}
+		}
+		switch g.Alert {
+		case alertPatrol:
+			if seen != nil {
+				h.suspect(g, seen)
+			}
+		case alertReturn:
+			if seen != nil {
+				h.suspect(g, seen)
+			} else if goalReached(g) || g.posted() {
+				h.setAlert(g, alertPatrol)
+			}
+		case alertSuspicious:
+			switch {
+			case seen == nil:
+				h.investigate(g)
+			case h.tick-g.alertedAt >= h.suspicionTicks():
+				h.startChase(g, seen)
+				h.radio(g, seen, m)
+			default:
+				g.suspect = seen.Id
+			}
+		case alertChase:
+			// The last-known position may be somewhere guards can't go, such as the spawn area, in
+			// which case the guard gets only as far as the end of its route
+			if seen == nil && (g.posted() || goalReached(g)) {
+				h.investigate(g)
+			}
+		case alertInvestigate:
+			if seen != nil {
+				h.startChase(g, seen) // already on edge, so no second look
+				h.radio(g, seen, m)
+			} else if h.tick-g.alertedAt >= h.ticksIn(h.cfg.InvestigateTime) {
+				h.returnToPatrol(g)
+			}
+		}
+	}
+}
+
+// spot returns the player g sees, if any. A chasing guard keeps its eyes on its target within
+// sight range whichever way it faces; otherwise a player has to be in the guard's vision cone.
+func (h *Hub) spot(g *guard, m model) *player {
+	if h.offline(g) {
+		return nil
+	}
+	if target := h.playerByID(g.chasing); target != nil {
+		at := state{x: target.X, y: target.Y}
+		if at.distanceTo(state{x: g.X, y: g.Y}) <= g.kind.SightRange && canSee(g, at, m) {
+			return target
+		}
+		return nil
+	}
+	for _, client := range h.joined {
+		p := h.players[client]
+		at := state{x: p.X, y: p.Y}
+		if inVisionCone(g, at) && canSee(g, at, m) {
+			return p
+		}
+	}
+	return nil
+}
+
+// suspect has g stop and watch p, which it has just noticed
+func (h *Hub) suspect(g *guard, p *player) {
+	h.setAlert(g, alertSuspicious)
+	g.suspect = p.Id
+}
+
+func (h *Hub) startChase(g *guard, p *player) {
+	h.setAlert(g, alertChase)
+	g.suspect = ""
+	g.chasing = p.Id
+	g.goal = state{
+		x: p.X,
+		y: p.Y,
+	}
+}
+
+// investigate has g search around where it last saw a player
+func (h *Hub) investigate(g *guard) {
+	h.setAlert(g, alertInvestigate)
+	g.suspect, g.chasing = "", ""
+	g.sweep = 0
+	g.searchHeading = math.Atan2(float64(g.lastKnown.y-g.Y), float64(g.lastKnown.x-g.X))
+	g.goal = g.lastKnown
+}
+
+// returnToPatrol sends g back to the nearest point on its patrol
+func (h *Hub) returnToPatrol(g *guard) {
+	h.setAlert(g, alertReturn)
+	g.suspect, g.chasing = "", ""
+	if len(g.patrolPoints) > 0 {
+		g.currentPoint = closestPatrolPoint(g)
+		g.goal = g.patrolPoints[g.currentPoint]
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// trailLength is how many recent positions are kept per player for validation
+const trailLength = 20
+
+// pickupTicks is how many of the most recent trail positions a pickup may have happened from
+const pickupTicks = 3
+
+// Allowance for float rounding between the client's prediction and the server
+const positionTolerance = 0.5
+
+const violationWindow = time.Minute
+const maxViolationsPerWindow = 10
+
+type violation struct {
+	time   time.Time
+	reason string
+}
+
+type violationReport struct {
+	Room       string    `json:"room"`
+	Id         string    `json:"id"`
+	Username   string    `json:"username"`
+	Violations int       `json:"violations"` // within the last violationWindow
+	LastReason string    `json:"lastReason"`
+	Kicked     bool      `json:"kicked"`
+	KickedAt   time.Time `json:"kickedAt"`
+}
+
+// recordTrail appends the player's current position to its trail
+func (p *player) recordTrail() {
+	if len(p.trail) >= trailLength {
+		p.trail = p.trail[1:]
+	}
+	p.trail = append(p.trail, state{x: p.X, y: p.Y})
+}
+
+// validateTrajectory checks that every step in trail could have been walked:
+// no faster than a sprint and never through an obstacle. Movement only checks where a step
+// ends, so a diagonal step past a corner can clip it by a sliver, which is allowed for.
+func validateTrajectory(trail []state, m model) error {
+	for i := 1; i < len(trail); i++ {
+		from, to := trail[i-1], trail[i]
+		distance := from.distanceTo(to)
+		if distance > m.sprintStep+positionTolerance {
+			return fmt.Errorf("moved %.1f units in one tick", distance)
+		}
+		// Steps are short, so sampling each unit along the step is enough to catch tunnelling
+		for j := float32(0); j <= distance; j++ {
+			t := j / max(distance, 1)
+			at := state{x: from.x + (to.x-from.x)*t, y: from.y + (to.y-from.y)*t}
+			for _, obs := range m.obstacles {
+				if circleIntersects(at, playerRadius-positionTolerance, obs) {
+					return errors.New("moved through an obstacle")
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// validatePickup checks that the player's recent trajectory is legal and passed within reach of it.
+func validatePickup(trail []state, it item, reach float32, m model) error {
+	if len(trail) == 0 {
+		return errors.New("no trajectory to pick up from")
+	}
+	if err := validateTrajectory(trail, m); err != nil {
+		return err
+	}
+	itemState := state{x: it.X, y: it.Y}
+	for i := max(0, len(trail)-pickupTicks); i < len(trail); i++ {
+		if trail[i].distanceTo(itemState) <= reach+positionTolerance {
+			return nil
+		}
+	}
+	return fmt.Errorf("out of reach at %.1f units", trail[len(trail)-1].distanceTo(itemState))
+}
+
+// flagViolation records a violation for client and kicks it once it has too many within violationWindow
+func (h *Hub) flagViolation(client *Client, reason string) {
+	p, ok := h.players[client]
+	if !ok || p.kicked {
+		return
+	}
+	now := h.now()
+	recent := p.flagged[:0]
+	for _, v := range p.flagged {
+		if now.Sub(v.time) < violationWindow {
+			recent = append(recent, v)
+		}
+	}
+	p.flagged = append(recent, violation{time: now, reason: reason})
+	log.Printf("violation by %s (%s), %d in the last %v: %s", p.Username, p.Id, len(p.flagged), violationWindow, reason)
+
+	if len(p.flagged) >= maxViolationsPerWindow {
+		p.kicked = true
+		log.Println("kicking", p.Username, "for too many violations")
+		h.kicked = append(h.kicked, violationReport{
+			Id:         p.Id,
+			Username:   p.Username,
+			Violations: len(p.flagged),
+			LastReason: reason,
+			Kicked:     true,
+			KickedAt:   now,
+		})
+		if len(h.kicked) > 50 {
+			h.kicked = h.kicked[1:]
+		}
+		go client.kick("too many violations") // the close handshake must not hold up the simulation
+	}
+}
+
+// violationReports lists every connected player with violations, followed by recently kicked players.
+// It is safe to call from any goroutine.
+func (h *Hub) violationReports() []violationReport {
+	reports := make([]violationReport, 0)
+	h.inspect(func(h *Hub) {
+		for _, p := range h.players {
+			if len(p.flagged) == 0 || p.kicked {
+				continue
+			}
+			reports = append(reports, violationReport{
+				Id:         p.Id,
+				Username:   p.Username,
+				Violations: len(p.flagged),
+				LastReason: p.flagged[len(p.flagged)-1].reason,
+			})
+		}
+		reports = append(reports, h.kicked...)
+	})
+	return reports
+}
+
+func (report violationReport) inRoom(id string) violationReport {
+	report.Room = id
+	return report
+}
+
+func adminViolations(rm *roomManager, w http.ResponseWriter, r *http.Request) {
+	serveReports(rm, w, r, (*Hub).violationReports)
+}
+
+// serveReports answers an admin request with what reports has to say about every room, each report
+// marked with the room it came from
+func serveReports[R interface{ inRoom(id string) R }](rm *roomManager, w http.ResponseWriter, r *http.Request, reports func(h *Hub) []R) {
+	if !adminAuthorized(rm.cfg.AdminToken, r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	all := make([]R, 0)
+	for id, hub := range rm.hubs() {
+		for _, report := range reports(hub) {
+			all = append(all, report.inRoom(id))
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(all)
+	if err != nil {
+		log.Println("error writing admin report:", err)
+	}
+}
+
+// adminAuthorized requires token when it is set, and a loopback request otherwise
+func adminAuthorized(token string, r *http.Request) bool {
+	if token != "" {
+		return r.Header.Get("Authorization") == "Bearer "+token
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
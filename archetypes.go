@@ -0,0 +1,128 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// The archetype every guard has unless its map says otherwise
+const standardArchetype = "guard"
+
+// An archetype is a kind of guard, with its own movement, perception and catch. Maps list theirs
+// under "guardTypes" and each guard names one with "type". A type leaving a setting out gets the
+// standard guard's, so a map only spells out what makes its guards different: slow heavies that see
+// far, dogs that run fast and hear well but see little, or posts that never move or catch anyone
+// but raise the alarm all the same.
+type archetype struct {
+	Name       string  `json:"name"`
+	Speed      float32 `json:"speed"`           // units per second, 0 for a guard that never leaves its post
+	TurnRate   float32 `json:"turnRate"`        // radians per second
+	Radius     float32 `json:"radius"`          // of the circle that collides with obstacles
+	KillRange  float32 `json:"killRange"`       // how close a chase must get to catch a player, 0 to never catch anyone
+	SightRange float32 `json:"sightRange"`      // how far the vision cone reaches
+	SightAngle float32 `json:"sightAngle"`      // full width of the vision cone, in radians
+	Hearing    float32 `json:"hearing"`         // how far noises carry, relative to the standard guard
+	Brain      string  `json:"brain,omitempty"` // the behavior tree in cfg.BrainDir the guard thinks with
+}
+
+// standardGuard is the archetype of guards on maps that define none, set up by cfg
+func standardGuard(cfg config) archetype {
+	return archetype{
+		Name:       standardArchetype,
+		Speed:      cfg.GuardSpeed,
+		TurnRate:   cfg.GuardTurnRate,
+		Radius:     guardRadius,
+		KillRange:  cfg.KillRadius,
+		SightRange: visionRange,
+		SightAngle: visionAngle,
+		Hearing:    1,
+		Brain:      standardBrain,
+	}
+}
+
+// readArchetypes reads a map's guard types. A type named like a built in one, the standard guard or
+// the camera, changes that one's settings; any other fills in from standard. Types that don't make
+// sense, or think with none of brains, are left out and reported.
+func readArchetypes(raw []json.RawMessage, standard archetype, brains brainSet) (map[string]archetype, error) {
+	types := map[string]archetype{standard.Name: standard, cameraArchetype: standardCamera()}
+	defined := make(map[string]bool) // built in types can be redefined, but only once
+	var errs []error
+	for _, data := range raw {
+		var named struct {
+			Name string `json:"name"`
+		}
+		_ = json.Unmarshal(data, &named) // reported below
+		a, builtIn := types[named.Name]
+		if !builtIn {
+			a = standard
+			a.Name = ""
+		}
+		if err := json.Unmarshal(data, &a); err != nil {
+			errs = append(errs, fmt.Errorf("guard type %s: %w", data, err))
+			continue
+		}
+		if defined[a.Name] {
+			errs = append(errs, fmt.Errorf("guard type %q is defined twice", a.Name))
+			continue
+		}
+		if err := a.validate(brains); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		types[a.Name] = a
+		defined[a.Name] = true
+	}
+	return types, errors.Join(errs...)
+}
+
+func (a archetype) validate(brains brainSet) error {
+	switch {
+	case a.Name == "":
+		return errors.New("guard type has no name")
+	case a.Radius <= 0:
+		return fmt.Errorf("guard type %q must have a positive radius", a.Name)
+	case a.Speed < 0 || a.TurnRate < 0 || a.KillRange < 0 || a.SightRange < 0 || a.Hearing < 0:
+		return fmt.Errorf("guard type %q has a negative setting", a.Name)
+	case a.SightAngle < 0 || a.SightAngle > 2*math.Pi:
+		return fmt.Errorf("guard type %q must see between 0 and 2π radians", a.Name)
+	case brains[a.Brain] == nil:
+		return fmt.Errorf("guard type %q has no brain %q", a.Name, a.Brain)
+	}
+	return nil
+}
+
+// posted reports whether g never leaves its post, so it only looks and raises the alarm
+func (g *guard) posted() bool {
+	return g.kind.Speed == 0
+}
+
+// guardTypes lists the archetypes of guards, once each by name, for clients to draw them by
+func guardTypes(guards []guard) []archetype {
+	var types []archetype
+	for _, g := range guards {
+		if !slices.ContainsFunc(types, func(a archetype) bool { return a.Name == g.kind.Name }) {
+			types = append(types, g.kind)
+		}
+	}
+	slices.SortFunc(types, func(a, b archetype) int { return cmp.Compare(a.Name, b.Name) })
+	return types
+}
+
+// navRadius is the radius of the widest guard that moves. Every guard walks the same navigation
+// grid, so it is built for that one, and narrower guards keep out of gaps they alone would fit.
+func navRadius(guards []guard) float32 {
+	var radius float32
+	for _, g := range guards {
+		if !g.posted() {
+			radius = max(radius, g.kind.Radius)
+		}
+	}
+	if radius == 0 {
+		return guardRadius
+	}
+	return radius
+}
@@ -0,0 +1,66 @@
+package main
+
+import "testing"
+
+func TestGuardTypesFillInFromStandard(t *testing.T) {
+	standard := standardGuard(defaultConfig())
+	_, _, guards, _, err := readWorldData([]byte(`{
+		"obstacles": [],
+		"items": [],
+		"guardTypes": [
+			{"name": "dog", "speed": 180, "sightRange": 120, "hearing": 2},
+			{"name": "ghost", "radius": -1}
+		],
+		"guards": [
+			{"id": "rex", "type": "dog", "x": 0, "y": -400, "patrolPoints": []},
+			{"id": "bob", "x": 0, "y": -500, "patrolPoints": []},
+			{"id": "casper", "type": "ghost", "x": 0, "y": -600, "patrolPoints": []}
+		]
+	}`), standard, standardBrains(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dog := standard
+	dog.Name, dog.Speed, dog.SightRange, dog.Hearing = "dog", 180, 120, 2
+	want := []archetype{dog, standard, standard}
+	for i, g := range guards {
+		if g.kind != want[i] {
+			t.Errorf("%s is %+v, want %+v", g.Id, g.kind, want[i])
+		}
+	}
+}
+
+// A camera over the spawn and a standard guard within radio range of it
+const cameraMap = `{
+	"obstacles": [],
+	"items": [],
+	"guardTypes": [{"name": "camera", "speed": 0, "killRange": 0, "radius": 10}],
+	"guards": [
+		{"id": "camera1", "type": "camera", "x": 0, "y": -300, "rotation": 3.14159, "patrolPoints": []},
+		{"id": "guard1", "x": 500, "y": -400, "rotation": 0, "patrolPoints": [{"x": 500, "y": -400}, {"x": 500, "y": -600}]}
+	]
+}`
+
+func TestPostedGuardRaisesAlarmWithoutMoving(t *testing.T) {
+	sim := newSimulation(t, cameraMap, 1)
+	thief := sim.join("thief")
+	sim.place(thief, 0, -250)
+	sim.player(thief).Score = 3
+	camera := sim.guard("camera1")
+
+	sim.run(sim.hub.ticksIn(sim.hub.cfg.SuspicionTime) + 1)
+	if camera.Alert != alertChase {
+		t.Fatalf("camera is %v, want it raising the alarm", camera.Alert)
+	}
+	if g := sim.guard("guard1"); g.Alert != alertInvestigate {
+		t.Errorf("guard1 is %v, want it answering the camera's call", g.Alert)
+	}
+
+	sim.run(60)
+	if camera.X != 0 || camera.Y != -300 {
+		t.Errorf("camera moved to (%v, %v)", camera.X, camera.Y)
+	}
+	if score := sim.player(thief).Score; score != 3 {
+		t.Errorf("player right in front of the camera has score %d, want it never caught by it", score)
+	}
+}
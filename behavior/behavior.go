@@ -0,0 +1,193 @@
+// Package behavior runs behavior trees: selectors, sequences and decorators over conditions and
+// actions that an agent provides. Nodes keep no state of their own, so one tree can be ticked for
+// many agents at once, each remembering what it needs on its own Blackboard.
+package behavior
+
+import "fmt"
+
+// A Status is what ticking a node comes to
+type Status int
+
+const (
+	Failure Status = iota
+	Success
+	Running // the node isn't finished, but is getting on with it
+)
+
+var statusNames = []string{"failure", "success", "running"}
+
+func (s Status) String() string {
+	if int(s) < len(statusNames) {
+		return statusNames[s]
+	}
+	return fmt.Sprint("status", int(s))
+}
+
+// A Blackboard is what one agent's tree remembers from one tick to the next
+type Blackboard map[string]any
+
+// Get returns the value of key on b, or the zero value if it has none of type T
+func Get[T any](b Blackboard, key string) T {
+	v, _ := b[key].(T)
+	return v
+}
+
+// A Tick is one evaluation of a tree for an agent. It keeps track of the path through the tree
+// to the node that decided the outcome.
+type Tick[A any] struct {
+	Agent A
+	Board Blackboard
+	path  []string
+}
+
+// Run ticks n as a child of the node being ticked
+func (t *Tick[A]) Run(n Node[A]) Status {
+	t.path = append(t.path, n.Name())
+	return n.Tick(t)
+}
+
+// rewind forgets the path below depth, when the node there was not the one that counted
+func (t *Tick[A]) rewind(depth int) {
+	t.path = t.path[:depth]
+}
+
+// A Node is one node of a tree acting on agents of type A
+type Node[A any] interface {
+	Name() string
+	Tick(t *Tick[A]) Status
+}
+
+// A Tree is a behavior tree for agents of type A
+type Tree[A any] struct {
+	root Node[A]
+}
+
+func NewTree[A any](root Node[A]) *Tree[A] {
+	return &Tree[A]{root: root}
+}
+
+// Tick evaluates the tree once for agent, and returns how it went and the names of the nodes from
+// the root to the one that decided it: the running or successful leaf, or the root on failure
+func (tr *Tree[A]) Tick(agent A, board Blackboard) (Status, []string) {
+	t := &Tick[A]{Agent: agent, Board: board}
+	status := t.Run(tr.root)
+	if status == Failure {
+		t.rewind(1)
+	}
+	return status, t.path
+}
+
+type composite[A any] struct {
+	name     string
+	children []Node[A]
+}
+
+func (c *composite[A]) Name() string { return c.name }
+
+type selector[A any] struct{ composite[A] }
+
+// Selector ticks its children in order until one doesn't fail, and comes to what that one does.
+// It fails if they all do.
+func Selector[A any](name string, children ...Node[A]) Node[A] {
+	return &selector[A]{composite[A]{name, children}}
+}
+
+func (s *selector[A]) Tick(t *Tick[A]) Status {
+	depth := len(t.path)
+	for _, child := range s.children {
+		if status := t.Run(child); status != Failure {
+			return status
+		}
+		t.rewind(depth)
+	}
+	return Failure
+}
+
+type sequence[A any] struct{ composite[A] }
+
+// Sequence ticks its children in order while they succeed. It comes to what the first that
+// doesn't does, or succeeds if they all do.
+func Sequence[A any](name string, children ...Node[A]) Node[A] {
+	return &sequence[A]{composite[A]{name, children}}
+}
+
+func (s *sequence[A]) Tick(t *Tick[A]) Status {
+	depth := len(t.path)
+	for i, child := range s.children {
+		if status := t.Run(child); status != Success || i == len(s.children)-1 {
+			return status
+		}
+		t.rewind(depth)
+	}
+	return Success
+}
+
+type decorator[A any] struct {
+	name   string
+	child  Node[A]
+	status func(Status) Status
+}
+
+func (d *decorator[A]) Name() string { return d.name }
+
+func (d *decorator[A]) Tick(t *Tick[A]) Status {
+	return d.status(t.Run(d.child))
+}
+
+// Invert turns its child's success into failure and its failure into success
+func Invert[A any](name string, child Node[A]) Node[A] {
+	return &decorator[A]{name, child, func(s Status) Status {
+		switch s {
+		case Success:
+			return Failure
+		case Failure:
+			return Success
+		}
+		return s
+	}}
+}
+
+// Succeed succeeds even when its child fails, for a child that is worth trying but not needed
+func Succeed[A any](name string, child Node[A]) Node[A] {
+	return &decorator[A]{name, child, func(s Status) Status {
+		if s == Failure {
+			return Success
+		}
+		return s
+	}}
+}
+
+type condition[A any] struct {
+	name  string
+	check func(A, Blackboard) bool
+}
+
+// Condition succeeds when check holds for the agent and fails otherwise
+func Condition[A any](name string, check func(A, Blackboard) bool) Node[A] {
+	return &condition[A]{name, check}
+}
+
+func (c *condition[A]) Name() string { return c.name }
+
+func (c *condition[A]) Tick(t *Tick[A]) Status {
+	if c.check(t.Agent, t.Board) {
+		return Success
+	}
+	return Failure
+}
+
+type action[A any] struct {
+	name string
+	act  func(A, Blackboard) Status
+}
+
+// Action has the agent do something, and comes to however that went
+func Action[A any](name string, act func(A, Blackboard) Status) Node[A] {
+	return &action[A]{name, act}
+}
+
+func (a *action[A]) Name() string { return a.name }
+
+func (a *action[A]) Tick(t *Tick[A]) Status {
+	return a.act(t.Agent, t.Board)
+}
@@ -0,0 +1,81 @@
+package behavior
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// A counter is an agent that counts up to a limit
+type counter struct {
+	n, limit int
+}
+
+var counting = Library[*counter]{
+	Conditions: map[string]func(*counter, Blackboard) bool{
+		"done": func(c *counter, _ Blackboard) bool { return c.n >= c.limit },
+	},
+	Actions: map[string]func(*counter, Blackboard) Status{
+		"count": func(c *counter, b Blackboard) Status {
+			c.n++
+			b["counted"] = Get[int](b, "counted") + 1
+			return Running
+		},
+		"fail": func(*counter, Blackboard) Status { return Failure },
+		"rest": func(*counter, Blackboard) Status { return Success },
+	},
+}
+
+const countingTree = `{"selector": [
+	{"name": "finished", "sequence": [{"condition": "done"}, {"action": "rest"}]},
+	{"name": "stuck", "sequence": [{"action": "fail"}, {"action": "rest"}]},
+	{"name": "busy", "sequence": [{"invert": {"condition": "done"}}, {"succeed": {"action": "fail"}}, {"action": "count"}]}
+], "name": "counter"}`
+
+func TestTreeTicksAndTracesPath(t *testing.T) {
+	tree, err := Load([]byte(countingTree), counting)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, board := &counter{limit: 2}, Blackboard{}
+	for _, want := range []struct {
+		status Status
+		path   []string
+	}{
+		{Running, []string{"counter", "busy", "count"}},
+		{Running, []string{"counter", "busy", "count"}},
+		{Success, []string{"counter", "finished", "rest"}},
+	} {
+		status, path := tree.Tick(c, board)
+		if status != want.status || !slices.Equal(path, want.path) {
+			t.Errorf("tick came to %v at %v, want %v at %v", status, path, want.status, want.path)
+		}
+	}
+	if counted := Get[int](board, "counted"); counted != 2 {
+		t.Errorf("blackboard counted %d, want 2", counted)
+	}
+}
+
+func TestFailingTreeTracesRoot(t *testing.T) {
+	tree := NewTree(Sequence[*counter]("root", Action[*counter]("fail", counting.Actions["fail"])))
+	if status, path := tree.Tick(&counter{}, Blackboard{}); status != Failure || !slices.Equal(path, []string{"root"}) {
+		t.Errorf("got %v at %v, want failure at the root", status, path)
+	}
+}
+
+func TestLoadRejectsBadTrees(t *testing.T) {
+	for name, tc := range map[string]struct {
+		json, err string
+	}{
+		"unknown action":    {`{"sequence": [{"action": "fly"}]}`, `root/sequence[0]: unknown action "fly"`},
+		"unknown condition": {`{"invert": {"condition": "tired"}}`, `root/invert: unknown condition "tired"`},
+		"empty selector":    {`{"selector": []}`, `root: selector has no children`},
+		"two kinds":         {`{"action": "rest", "condition": "done"}`, `root: node is action and condition at once`},
+		"no kind":           {`{"name": "nothing"}`, `root: node is none of`},
+	} {
+		_, err := Load([]byte(tc.json), counting)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: got error %v, want %q", name, err, tc.err)
+		}
+	}
+}
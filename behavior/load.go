@@ -0,0 +1,117 @@
+package behavior
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// A Library holds the conditions and actions trees loaded for agents of type A can use, by name
+type Library[A any] struct {
+	Conditions map[string]func(A, Blackboard) bool
+	Actions    map[string]func(A, Blackboard) Status
+}
+
+// A spec is one node as JSON describes it. Exactly one of the node kinds is set:
+//
+//	{"selector": [children...], "name": "optional"}
+//	{"sequence": [children...], "name": "optional"}
+//	{"invert": child, "name": "optional"}
+//	{"succeed": child, "name": "optional"}
+//	{"condition": "name in the library"}
+//	{"action": "name in the library"}
+type spec struct {
+	Name      string `json:"name"`
+	Selector  []spec `json:"selector"`
+	Sequence  []spec `json:"sequence"`
+	Invert    *spec  `json:"invert"`
+	Succeed   *spec  `json:"succeed"`
+	Condition string `json:"condition"`
+	Action    string `json:"action"`
+}
+
+// Load builds the tree data describes out of the conditions and actions in lib
+func Load[A any](data []byte, lib Library[A]) (*Tree[A], error) {
+	var root spec
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	n, err := build(root, lib, "root")
+	if err != nil {
+		return nil, err
+	}
+	return NewTree(n), nil
+}
+
+// build makes the node s describes, which is at path in the tree for error messages
+func build[A any](s spec, lib Library[A], path string) (Node[A], error) {
+	var kinds []string
+	for kind, set := range map[string]bool{
+		"selector":  s.Selector != nil,
+		"sequence":  s.Sequence != nil,
+		"invert":    s.Invert != nil,
+		"succeed":   s.Succeed != nil,
+		"condition": s.Condition != "",
+		"action":    s.Action != "",
+	} {
+		if set {
+			kinds = append(kinds, kind)
+		}
+	}
+	slices.Sort(kinds)
+	switch len(kinds) {
+	case 0:
+		return nil, fmt.Errorf("%s: node is none of selector, sequence, invert, succeed, condition or action", path)
+	case 1:
+	default:
+		return nil, fmt.Errorf("%s: node is %s at once", path, strings.Join(kinds, " and "))
+	}
+	kind := kinds[0]
+	name := cmp.Or(s.Name, kind)
+	switch kind {
+	case "selector", "sequence":
+		specs, composite := s.Selector, Selector[A]
+		if kind == "sequence" {
+			specs, composite = s.Sequence, Sequence[A]
+		}
+		if len(specs) == 0 {
+			return nil, fmt.Errorf("%s: %s has no children", path, kind)
+		}
+		children := make([]Node[A], len(specs))
+		var errs []error
+		for i, child := range specs {
+			var err error
+			children[i], err = build(child, lib, fmt.Sprintf("%s/%s[%d]", path, name, i))
+			errs = append(errs, err)
+		}
+		if err := errors.Join(errs...); err != nil {
+			return nil, err
+		}
+		return composite(name, children...), nil
+	case "invert", "succeed":
+		child, decorate := s.Invert, Invert[A]
+		if kind == "succeed" {
+			child, decorate = s.Succeed, Succeed[A]
+		}
+		n, err := build(*child, lib, path+"/"+name)
+		if err != nil {
+			return nil, err
+		}
+		return decorate(name, n), nil
+	case "condition":
+		check, found := lib.Conditions[s.Condition]
+		if !found {
+			return nil, fmt.Errorf("%s: unknown condition %q", path, s.Condition)
+		}
+		return Condition(cmp.Or(s.Name, s.Condition), check), nil
+	default:
+		act, found := lib.Actions[s.Action]
+		if !found {
+			return nil, fmt.Errorf("%s: unknown action %q", path, s.Action)
+		}
+		return Action(cmp.Or(s.Name, s.Action), act), nil
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Aries1542/Infiltrate/behavior"
+)
+
+// The brain guards think with unless their archetype names another
+const standardBrain = "guard"
+
+// Keys of what a guard's brain remembers on its blackboard
+const (
+	failedPathsKey = "failedPaths" // paths in a row the guard could not find, an int
+)
+
+// A thought is one guard thinking on a planner, what the leaves of its brain act on
+type thought struct {
+	ctx     context.Context
+	g       *guard
+	m       model
+	rng     *rand.Rand
+	actions []action // decided on so far
+}
+
+// A brainSet is every behavior tree a room's guards can think with, by name. Trees keep no state,
+// so every guard with a brain shares it, on whichever planner it thinks.
+type brainSet map[string]*behavior.Tree[*thought]
+
+// loadBrains reads every tree in the JSON files in dir, each named after its file. Archetypes pick
+// theirs by name. Rooms load them as they start, so a changed brain is taken up by the next room
+// without rebuilding the server.
+func loadBrains(dir string) (brainSet, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	loaded := make(brainSet)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		tree, err := behavior.Load(data, guardLeaves)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		loaded[strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))] = tree
+	}
+	if loaded[standardBrain] == nil {
+		return nil, fmt.Errorf("%s has no %s.json for standard guards to think with", dir, standardBrain)
+	}
+	return loaded, nil
+}
+
+// guardLeaves are the conditions and actions brains are made of
+var guardLeaves = behavior.Library[*thought]{
+	Conditions: map[string]func(*thought, behavior.Blackboard) bool{
+		"patrolling":    inAlert(alertPatrol),
+		"suspicious":    inAlert(alertSuspicious),
+		"chasing":       inAlert(alertChase),
+		"investigating": inAlert(alertInvestigate),
+		"returning":     inAlert(alertReturn),
+		"goalReached":   func(th *thought, _ behavior.Blackboard) bool { return goalReached(th.g) },
+		"stuck":         func(th *thought, _ behavior.Blackboard) bool { return th.g.stuck },
+	},
+	Actions: map[string]func(*thought, behavior.Blackboard) behavior.Status{
+		"watch":             watch,
+		"nextPatrolPoint":   nextPatrolPoint,
+		"randomPatrolPoint": randomPatrolPoint,
+		"followLastKnown":   followLastKnown,
+		"nextSearchPoint":   nextSearchPoint,
+		"walkToGoal":        walkToGoal,
+		"escape":            escape,
+		"recover":           recoverPath,
+	},
+}
+
+func inAlert(a alert) func(*thought, behavior.Blackboard) bool {
+	return func(th *thought, _ behavior.Blackboard) bool { return th.g.Alert == a }
+}
+
+// watch has the guard stand still, keeping an eye on something
+func watch(*thought, behavior.Blackboard) behavior.Status {
+	return behavior.Running
+}
+
+func nextPatrolPoint(th *thought, _ behavior.Blackboard) behavior.Status {
+	g := th.g
+	if len(g.patrolPoints) == 0 {
+		return behavior.Failure
+	}
+	g.currentPoint = (g.currentPoint + 1) % len(g.patrolPoints)
+	g.goal = g.patrolPoints[g.currentPoint]
+	return behavior.Success
+}
+
+// randomPatrolPoint picks any patrol point but the current one, for guards that roam their patrol
+func randomPatrolPoint(th *thought, _ behavior.Blackboard) behavior.Status {
+	g := th.g
+	if len(g.patrolPoints) < 2 {
+		return nextPatrolPoint(th, nil)
+	}
+	g.currentPoint = (g.currentPoint + 1 + th.rng.IntN(len(g.patrolPoints)-1)) % len(g.patrolPoints)
+	g.goal = g.patrolPoints[g.currentPoint]
+	return behavior.Success
+}
+
+// followLastKnown heads for where the guard last saw the player, kept up to date while it sees them
+func followLastKnown(th *thought, _ behavior.Blackboard) behavior.Status {
+	th.g.goal = th.g.lastKnown
+	return behavior.Success
+}
+
+// nextSearchPoint moves an investigating guard on to the next place it looks
+func nextSearchPoint(th *thought, _ behavior.Blackboard) behavior.Status {
+	th.g.sweep++
+	th.g.goal = searchPoint(th.g)
+	return behavior.Success
+}
+
+// walkToGoal plans the guard's way to its goal. It fails if there is none, and is running while
+// the guard has the walk ahead of it.
+func walkToGoal(th *thought, board behavior.Blackboard) behavior.Status {
+	g := th.g
+	path, err := planRoute(th.ctx, g, th.m)
+	if th.ctx.Err() != nil {
+		return behavior.Running // not a failure, the plan was called off and will be thrown away
+	}
+	g.route = path
+	if err != nil {
+		return behavior.Failure
+	}
+	if len(path) == 0 {
+		g.route = []state{{x: g.X, y: g.Y}} // already as near the goal as it gets
+	}
+	th.actions = walkActions(state{x: g.X, y: g.Y}, path, th.m.guardStep(g))
+	board[failedPathsKey] = 0
+	if len(th.actions) == 0 {
+		return behavior.Success
+	}
+	return behavior.Running
+}
+
+// A brainReport is the path through one guard's brain to what it last decided, for the admin endpoint
+type brainReport struct {
+	Room  string   `json:"room"`
+	Guard string   `json:"guard"`
+	Brain string   `json:"brain"`
+	Path  []string `json:"path"`
+}
+
+// brainReports lists where every guard's brain is at. It is safe to call from any goroutine.
+func (h *Hub) brainReports() []brainReport {
+	reports := make([]brainReport, 0)
+	h.inspect(func(h *Hub) {
+		for _, g := range h.guards {
+			reports = append(reports, brainReport{Guard: g.Id, Brain: g.kind.Brain, Path: g.thinking})
+		}
+	})
+	return reports
+}
+
+func (report brainReport) inRoom(id string) brainReport {
+	report.Room = id
+	return report
+}
+
+func adminBrains(rm *roomManager, w http.ResponseWriter, r *http.Request) {
+	serveReports(rm, w, r, (*Hub).brainReports)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBrainTracesWhatGuardDecided(t *testing.T) {
+	sim := newSimulation(t, watchtowerMap, 1)
+	g := sim.guard("guard1")
+	sim.run(sim.hub.ticksIn(sim.hub.cfg.ThinkInterval))
+	if want := []string{"guard", "patrol", "walkToGoal"}; !slices.Equal(g.thinking, want) {
+		t.Errorf("patrolling guard's brain is at %v, want %v", g.thinking, want)
+	}
+
+	thief := sim.join("thief")
+	sim.place(thief, 0, -250)
+	sim.run(sim.hub.ticksIn(sim.hub.cfg.SuspicionTime) + sim.hub.ticksIn(sim.hub.cfg.ThinkInterval))
+	if want := []string{"guard", "chase", "walkToGoal"}; !slices.Equal(g.thinking, want) {
+		t.Errorf("chasing guard's brain is at %v, want %v", g.thinking, want)
+	}
+}
+
+func TestHoundRoamsItsPatrol(t *testing.T) {
+	sim := newSimulation(t, `{
+		"obstacles": [],
+		"items": [],
+		"guardTypes": [{"name": "hound", "speed": 400, "brain": "hound"}],
+		"guards": [{"id": "rex", "type": "hound", "x": 0, "y": -400, "patrolPoints": [
+			{"x": 0, "y": -400}, {"x": 200, "y": -400}, {"x": 200, "y": -600}, {"x": 0, "y": -600}
+		]}]
+	}`, 1)
+	g := sim.guard("rex")
+	var visited []int
+	for range 1200 {
+		sim.run(1)
+		if len(visited) == 0 || visited[len(visited)-1] != g.currentPoint {
+			visited = append(visited, g.currentPoint)
+		}
+	}
+	inOrder := true
+	for i := 1; i < len(visited); i++ {
+		inOrder = inOrder && visited[i] == (visited[i-1]+1)%len(g.patrolPoints)
+	}
+	if len(visited) < 4 || inOrder {
+		t.Errorf("hound went to patrol points %v, want it roaming between them out of order", visited)
+	}
+	if want := []string{"hound", "roam"}; !slices.Equal(g.thinking[:2], want) {
+		t.Errorf("hound's brain is at %v, want it under %v", g.thinking, want)
+	}
+}
+
+func TestUnknownBrainIsRejected(t *testing.T) {
+	_, err := readArchetypes([]json.RawMessage{[]byte(`{"name": "zombie", "brain": "braaains"}`)}, standardGuard(defaultConfig()), standardBrains(t))
+	if err == nil || !strings.Contains(err.Error(), `no brain "braaains"`) {
+		t.Errorf("got %v, want the unknown brain reported", err)
+	}
+}
+
+// Brains come from the brain directory, so they can be changed without rebuilding the server
+func TestBrainsAreReadFromTheBrainDir(t *testing.T) {
+	dir := t.TempDir()
+	guardBrain, err := os.ReadFile("./brains/guard.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string]string{
+		"guard.json": string(guardBrain),
+		"sloth.json": `{"name": "sloth", "action": "watch"}`,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := defaultConfig()
+	cfg.BrainDir = dir
+	hub, err := newHubFromMap(cfg, []byte(`{
+		"obstacles": [],
+		"items": [],
+		"guardTypes": [{"name": "sloth", "brain": "sloth"}],
+		"guards": [{"id": "lazy", "type": "sloth", "x": 0, "y": -400, "patrolPoints": [{"x": 0, "y": -400}, {"x": 0, "y": -600}]}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hub.stop()
+	hub.planGuards(hub.model())
+	hub.awaitPlans()
+	hub.adoptPlans()
+	if g := hub.guards[0]; !slices.Equal(g.thinking, []string{"sloth"}) {
+		t.Errorf("sloth's brain is at %v, want it watching", g.thinking)
+	}
+
+	os.Remove(filepath.Join(dir, "guard.json"))
+	if _, err := newHubFromMap(cfg, []byte(`{"obstacles": [], "items": [], "guards": []}`)); err == nil {
+		t.Error("made a hub without a brain for standard guards")
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/Aries1542/Infiltrate/behavior"
+)
+
+// The archetype of the security cameras maps list under "cameras"
+const cameraArchetype = "camera"
+
+// How far from its center a player can reach a terminal from, like a coin's radius
+const terminalRadius = 15
+
+// standardCamera is the archetype of cameras on maps that don't redefine it. A camera is a guard
+// that never leaves its post, catches no one and hears nothing, but sees further than a guard.
+func standardCamera() archetype {
+	return archetype{
+		Name:       cameraArchetype,
+		TurnRate:   math.Pi / 2,
+		Radius:     10,
+		SightRange: 300,
+		SightAngle: math.Pi / 3,
+		Brain:      standardBrain, // for maps that let cameras move
+	}
+}
+
+// A pan is how a camera looks around while nothing has its attention: it turns from one rotation
+// to the other and back, once every period
+type pan struct {
+	from   float32
+	to     float32
+	period duration
+}
+
+// A mapCamera is a camera as a map file describes it. Using the terminal with the id in Terminal,
+// if any, switches it off for a while.
+type mapCamera struct {
+	Id       string   `json:"id"`
+	X        float32  `json:"x"`
+	Y        float32  `json:"y"`
+	From     float32  `json:"from"`
+	To       float32  `json:"to"`
+	Period   duration `json:"period"`
+	Terminal string   `json:"terminal"`
+}
+
+// guard makes c into the guard that stands in for it in the simulation
+func (c mapCamera) guard(kind archetype) (guard, error) {
+	if c.Period.Duration <= 0 && c.From != c.To {
+		return guard{}, fmt.Errorf("camera %s must have a positive period to pan in", c.Id)
+	}
+	return guard{
+		Id:       c.Id,
+		kind:     kind,
+		board:    make(behavior.Blackboard),
+		X:        c.X,
+		Y:        c.Y,
+		Rotation: c.From,
+		actions:  make([]action, 0),
+		goal:     state{x: c.X, y: c.Y},
+		pan:      &pan{from: c.From, to: c.To, period: c.Period},
+		terminal: c.Terminal,
+	}, nil
+}
+
+// readCameras reads a map's cameras as guards of the camera type in types
+func readCameras(raw []json.RawMessage, types map[string]archetype) ([]guard, []error) {
+	var cameras []guard
+	var errs []error
+	for _, data := range raw {
+		var c mapCamera
+		if err := json.Unmarshal(data, &c); err != nil {
+			errs = append(errs, fmt.Errorf("camera %s: %w", data, err))
+			continue
+		}
+		g, err := c.guard(types[cameraArchetype])
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		cameras = append(cameras, g)
+	}
+	return cameras, errs
+}
+
+// heading is where a camera panning by p faces at tick, turning at an even rate each way
+func (p *pan) heading(tick, period int) float32 {
+	phase := float32(tick%period) / float32(period) * 2
+	if phase > 1 {
+		phase = 2 - phase
+	}
+	return p.from + (p.to-p.from)*phase
+}
+
+// offline reports whether g has been switched off at a terminal
+func (h *Hub) offline(g *guard) bool {
+	return h.tick < g.offlineUntil
+}
+
+// useTerminal switches off every camera wired to the terminal with id for cfg.CameraDowntime.
+// Whatever they were watching, they lose sight of.
+func (h *Hub) useTerminal(id string) {
+	for i := range h.guards {
+		if g := &h.guards[i]; g.terminal == id {
+			g.offlineUntil = h.tick + h.ticksIn(h.cfg.CameraDowntime)
+		}
+	}
+}
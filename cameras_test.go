@@ -0,0 +1,72 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// A camera north of the test players, panning either side of south, wired to a terminal beside
+// them, and a guard within radio range
+const securityMap = `{
+	"obstacles": [],
+	"items": [{"id": "terminal1", "type": "terminal", "x": 40, "y": -250}],
+	"guards": [
+		{"id": "guard1", "x": 500, "y": -400, "rotation": 0, "patrolPoints": [{"x": 500, "y": -400}, {"x": 500, "y": -600}]}
+	],
+	"cameras": [
+		{"id": "camera1", "x": 0, "y": -400, "from": 2.84, "to": 3.44, "period": "4s", "terminal": "terminal1"}
+	]
+}`
+
+func TestCameraPans(t *testing.T) {
+	sim := newSimulation(t, securityMap, 1)
+	camera := sim.guard("camera1")
+	for _, at := range []struct {
+		seconds  float64
+		rotation float64
+	}{{1, 3.14}, {1, 3.44}, {2, 2.84}} {
+		sim.run(int(at.seconds * float64(sim.hub.cfg.TickRate)))
+		if off := math.Remainder(float64(camera.Rotation)-at.rotation, 2*math.Pi); math.Abs(off) > 0.02 {
+			t.Errorf("camera faces %v, want %v", camera.Rotation, at.rotation)
+		}
+	}
+	if camera.X != 0 || camera.Y != -400 {
+		t.Errorf("camera moved to (%v, %v)", camera.X, camera.Y)
+	}
+}
+
+func TestCameraCallsInPlayer(t *testing.T) {
+	sim := newSimulation(t, securityMap, 1)
+	thief := sim.join("thief")
+	sim.place(thief, 0, -250)
+
+	sim.run(sim.hub.ticksIn(sim.hub.cfg.SuspicionTime) + 1)
+	if camera := sim.guard("camera1"); camera.Alert != alertChase {
+		t.Fatalf("camera is %v, want it raising the alarm", camera.Alert)
+	}
+	if g := sim.guard("guard1"); g.Alert != alertInvestigate {
+		t.Errorf("guard1 is %v, want it answering the camera's call", g.Alert)
+	}
+}
+
+func TestTerminalSwitchesCameraOff(t *testing.T) {
+	sim := newSimulation(t, securityMap, 1)
+	thief := sim.join("thief")
+	sim.place(thief, 0, -250)
+	thief.wait(1)
+	thief.grab("terminal1")
+	camera := sim.guard("camera1")
+
+	downtime := sim.hub.ticksIn(sim.hub.cfg.CameraDowntime)
+	sim.run(downtime - 1)
+	if camera.Alert != alertPatrol {
+		t.Fatalf("switched off camera is %v", camera.Alert)
+	}
+	sim.run(2)
+	if camera.Alert != alertSuspicious {
+		t.Errorf("camera is %v once back on, want it suspicious of the player in front of it", camera.Alert)
+	}
+	if len(sim.hub.items) != 1 {
+		t.Error("terminal was used up")
+	}
+}
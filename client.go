@@ -8,16 +8,34 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
-var upgrader = websocket.Upgrader{}
+// Sent when a join that passed the checks before the upgrade fails them on reaching the room
+const closeJoinRefused = 4001
+
+var upgrader = websocket.Upgrader{
+	Subprotocols: []string{binarySubprotocol, jsonSubprotocol},
+}
 
 type Client struct {
-	hub      *Hub
-	conn     *websocket.Conn
-	outgoing chan response
+	hub    *Hub
+	conn   *websocket.Conn
+	queue  *sendQueue
+	binary bool // whether the client negotiated the binary protocol instead of JSON
+}
+
+// send queues message for the client without blocking the caller. A client that can't keep up
+// is disconnected rather than allowed to hold up the hub.
+func (c *Client) send(message response, kind queuedKind) {
+	err := c.queue.push(message, kind)
+	if err != nil {
+		log.Println("disconnecting slow client:", err)
+		c.queue.close()
+		go c.disconnect(closeSlowConsumer, err.Error())
+	}
 }
 
 // fromClient pumps messages from the websocket connection to the hub.
@@ -31,7 +49,7 @@ func (c *Client) fromClient() {
 		}
 	}()
 	for {
-		_, message, err := c.conn.ReadMessage()
+		messageType, message, err := c.conn.ReadMessage()
 		var closeError *websocket.CloseError
 		if err != nil {
 			if !errors.As(err, &closeError) {
@@ -39,6 +57,16 @@ func (c *Client) fromClient() {
 			}
 			return
 		}
+		if messageType == websocket.BinaryMessage {
+			updating, err := decodeUpdateRequest(message)
+			if err != nil {
+				log.Println("error decoding request:", err)
+				continue
+			}
+			updating.client = c
+			c.hub.submit(updating)
+			continue
+		}
 		requesting := struct {
 			Requesting string
 		}{}
@@ -55,14 +83,14 @@ func (c *Client) fromClient() {
 				log.Println("error unmarshalling request:", err)
 				break
 			}
-			c.hub.incoming <- updating
+			c.hub.submit(updating)
 		}
 	}
 }
 
 func (c *Client) toClient() {
 	defer func() {
-		c.hub.incoming <- leaveRequest{client: c}
+		c.hub.submit(leaveRequest{client: c})
 		err := c.conn.Close()
 		if err != nil && !errors.Is(err, net.ErrClosed) {
 			log.Println("ws connection unable to close:", err)
@@ -71,13 +99,22 @@ func (c *Client) toClient() {
 		}
 	}()
 	for {
-		message := <-c.outgoing
-		jsonMessage, err := message.JSONFormat()
+		message, ok := c.queue.next()
+		if !ok {
+			return
+		}
+		messageType := websocket.TextMessage
+		format := message.JSONFormat
+		if c.binary {
+			messageType = websocket.BinaryMessage
+			format = message.BinaryFormat
+		}
+		encoded, err := format()
 		if err != nil {
 			log.Println("error marshaling response: ", err)
 			continue
 		}
-		err = c.conn.WriteMessage(websocket.TextMessage, jsonMessage)
+		err = c.conn.WriteMessage(messageType, encoded)
 		if err != nil {
 			if err.Error() != "websocket: close sent" {
 				log.Println("msg to client failed:", err)
@@ -87,33 +124,70 @@ func (c *Client) toClient() {
 	}
 }
 
-func connectClient(hub *Hub, w http.ResponseWriter, r *http.Request) {
+// kick closes the client's connection, which makes its pumps remove it from the hub.
+func (c *Client) kick(reason string) {
+	c.disconnect(websocket.ClosePolicyViolation, reason)
+}
+
+// disconnect tells the client why with a close frame and then closes its connection
+func (c *Client) disconnect(code int, reason string) {
+	message := websocket.FormatCloseMessage(code, reason)
+	err := c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+	if err != nil {
+		log.Println("unable to send close message:", err)
+	}
+	c.conn.Close()
+}
+
+func connectClient(rooms *roomManager, w http.ResponseWriter, r *http.Request) {
+	joining, ok := rooms.requestedRoom(w, r)
+	if !ok {
+		return
+	}
+	hub := joining.hub
 	username := r.URL.Query().Get("username")
 	valid, reason := usernameValid(hub, username)
 	if !valid {
 		http.Error(w, reason, http.StatusBadRequest)
 		return
 	}
-	log.Println(username, "has joined")
+	log.Println(username, "has joined room", joining.id)
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Print("upgrade failed: ", err)
 		return
 	}
+	attachClient(hub, conn, username, joining.maxPlayers)
+}
+
+// attachClient joins an upgraded connection to hub as username, if it has room for it, and starts
+// pumping its messages
+func attachClient(hub *Hub, conn *websocket.Conn, username string, maxPlayers int) *Client {
 	client := &Client{
-		hub:      hub,
-		conn:     conn,
-		outgoing: make(chan response),
+		hub:    hub,
+		conn:   conn,
+		queue:  newSendQueue(hub.cfg.SendQueueLength, hub.cfg.SlowClientTimeout.Duration),
+		binary: conn.Subprotocol() == binarySubprotocol,
+	}
+	if !client.hub.submit(joinRequest{client: client, username: username, maxPlayers: maxPlayers}) {
+		// The room closed since it was looked up, so there is nothing to pump messages to
+		client.disconnect(closeJoinRefused, "room closed")
+		client.queue.close()
+		return client
 	}
-	client.hub.incoming <- joinRequest{client: client, username: username}
 
 	go client.toClient()
 	go client.fromClient()
+	return client
 }
 
-func requestUsername(hub *Hub, w http.ResponseWriter, r *http.Request) {
+func requestUsername(rooms *roomManager, w http.ResponseWriter, r *http.Request) {
+	joining, ok := rooms.requestedRoom(w, r)
+	if !ok {
+		return
+	}
 	username := r.URL.Query().Get("username")
-	valid, reason := usernameValid(hub, username)
+	valid, reason := usernameValid(joining.hub, username)
 	if !valid {
 		http.Error(w, reason, http.StatusBadRequest)
 		return
@@ -121,6 +195,7 @@ func requestUsername(hub *Hub, w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// usernameValid checks username for joining hub; names only need to be unique within a room
 func usernameValid(hub *Hub, username string) (bool, string) {
 	if len(username) < 1 || len(username) > 15 {
 		return false, "username has bad length"
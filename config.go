@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Every setting can be set by the config file, then overridden by an environment variable
+// (INFILTRATE_ followed by the flag name in upper snake case), then by a command-line flag.
+const envPrefix = "INFILTRATE_"
+
+// A config holds everything an operator can tune about a deployment without recompiling
+type config struct {
+	Addr       string            `json:"addr"`
+	Maps       map[string]string `json:"maps"` // map name to map file path
+	DefaultMap string            `json:"defaultMap"`
+	BrainDir   string            `json:"brainDir"`   // where the behavior trees guards think with are read from
+	AdminToken string            `json:"adminToken"` // when empty, the admin endpoints only answer loopback requests
+
+	TickRate            int      `json:"tickRate"`            // simulation steps per second, each ending in a snapshot
+	ThinkInterval       duration `json:"thinkInterval"`       // how often guards replan
+	CoinRespawnInterval duration `json:"coinRespawnInterval"` // how often coins are reset to the map's layout
+	RoomIdleTimeout     duration `json:"roomIdleTimeout"`
+	MaxRooms            int      `json:"maxRooms"`        // rooms that can be open at once, the public room included
+	SuspicionTime       duration `json:"suspicionTime"`   // how long a guard watches a player before giving chase
+	InvestigateTime     duration `json:"investigateTime"` // how long a guard searches for a player it lost
+	RadioCooldown       duration `json:"radioCooldown"`   // how soon a guard can radio for help again
+	AlertDecay          duration `json:"alertDecay"`      // how long the map takes to calm down from full alert
+	CameraDowntime      duration `json:"cameraDowntime"`  // how long a terminal switches its cameras off for
+	StuckTime           duration `json:"stuckTime"`       // how long a guard can walk without getting anywhere before it backs out
+	PlanWorkers         int      `json:"planWorkers"`     // guards each room plans for at once
+	PlanDeadline        int      `json:"planDeadline"`    // ticks a guard's plan may take before it is cancelled
+
+	GuardSpeed    float32 `json:"guardSpeed"`    // units per second, for standard guards like the turn rate and kill radius
+	GuardTurnRate float32 `json:"guardTurnRate"` // radians per second
+	RadioRange    float32 `json:"radioRange"`    // how far a guard's call for help reaches on a calm map
+	KillRadius    float32 `json:"killRadius"`
+	PickupRadius  float32 `json:"pickupRadius"` // how far from its center a player can reach
+	CoinRadius    float32 `json:"coinRadius"`
+	ViewRadius    float32 `json:"viewRadius"` // how far from a player other entities are sent to it
+
+	SendQueueLength   int      `json:"sendQueueLength"`   // messages a client may have waiting before it is disconnected
+	SlowClientTimeout duration `json:"slowClientTimeout"` // how long a client may go without taking a snapshot before it is disconnected
+}
+
+func defaultConfig() config {
+	return config{
+		Addr:                ":8080",
+		Maps:                map[string]string{"default": "./mapData.json"},
+		DefaultMap:          "default",
+		BrainDir:            "./brains",
+		TickRate:            60,
+		ThinkInterval:       duration{200 * time.Millisecond},
+		CoinRespawnInterval: duration{2 * time.Minute},
+		RoomIdleTimeout:     duration{5 * time.Minute},
+		MaxRooms:            20,
+		SuspicionTime:       duration{time.Second},
+		InvestigateTime:     duration{8 * time.Second},
+		RadioCooldown:       duration{5 * time.Second},
+		AlertDecay:          duration{30 * time.Second},
+		CameraDowntime:      duration{15 * time.Second},
+		StuckTime:           duration{5 * time.Second},
+		PlanWorkers:         4,
+		PlanDeadline:        30,
+		GuardSpeed:          100,
+		GuardTurnRate:       2 * math.Pi,
+		RadioRange:          600,
+		KillRadius:          50,
+		PickupRadius:        25,
+		CoinRadius:          10,
+		ViewRadius:          900,
+		SendQueueLength:     64,
+		SlowClientTimeout:   duration{2 * time.Second},
+	}
+}
+
+// A setting is one config field that can be overridden from the environment or command line
+type setting struct {
+	name  string
+	usage string
+	apply func(cfg *config, value string) error
+}
+
+var settings = []setting{
+	{"addr", "address to listen on", func(cfg *config, value string) error {
+		cfg.Addr = value
+		return nil
+	}},
+	{"map", "path of the default map file", func(cfg *config, value string) error {
+		if cfg.Maps == nil {
+			cfg.Maps = make(map[string]string)
+		}
+		cfg.Maps[cfg.DefaultMap] = value
+		return nil
+	}},
+	{"brain-dir", "directory of the behavior trees guards think with", func(cfg *config, value string) error {
+		cfg.BrainDir = value
+		return nil
+	}},
+	{"admin-token", "bearer token required by the admin endpoints", func(cfg *config, value string) error {
+		cfg.AdminToken = value
+		return nil
+	}},
+	{"tick-rate", "simulation steps per second", func(cfg *config, value string) error {
+		tickRate, err := strconv.Atoi(value)
+		cfg.TickRate = tickRate
+		return err
+	}},
+	{"think-interval", "how often guards replan", durationSetter(func(cfg *config) *duration { return &cfg.ThinkInterval })},
+	{"coin-respawn-interval", "how often coins are reset", durationSetter(func(cfg *config) *duration { return &cfg.CoinRespawnInterval })},
+	{"room-idle-timeout", "how long an empty room lives", durationSetter(func(cfg *config) *duration { return &cfg.RoomIdleTimeout })},
+	{"max-rooms", "rooms that can be open at once", func(cfg *config, value string) error {
+		rooms, err := strconv.Atoi(value)
+		cfg.MaxRooms = rooms
+		return err
+	}},
+	{"suspicion-time", "how long a guard watches a player before giving chase", durationSetter(func(cfg *config) *duration { return &cfg.SuspicionTime })},
+	{"investigate-time", "how long a guard searches for a player it lost", durationSetter(func(cfg *config) *duration { return &cfg.InvestigateTime })},
+	{"radio-cooldown", "how soon a guard can radio for help again", durationSetter(func(cfg *config) *duration { return &cfg.RadioCooldown })},
+	{"alert-decay", "how long the map takes to calm down from full alert", durationSetter(func(cfg *config) *duration { return &cfg.AlertDecay })},
+	{"camera-downtime", "how long a terminal switches its cameras off for", durationSetter(func(cfg *config) *duration { return &cfg.CameraDowntime })},
+	{"stuck-time", "how long a guard can walk without getting anywhere before it backs out", durationSetter(func(cfg *config) *duration { return &cfg.StuckTime })},
+	{"plan-workers", "guards each room plans for at once", func(cfg *config, value string) error {
+		workers, err := strconv.Atoi(value)
+		cfg.PlanWorkers = workers
+		return err
+	}},
+	{"plan-deadline", "ticks a guard's plan may take before it is cancelled", func(cfg *config, value string) error {
+		deadline, err := strconv.Atoi(value)
+		cfg.PlanDeadline = deadline
+		return err
+	}},
+	{"guard-speed", "standard guard movement per second", float32Setter(func(cfg *config) *float32 { return &cfg.GuardSpeed })},
+	{"guard-turn-rate", "how far a guard can turn per second, in radians", float32Setter(func(cfg *config) *float32 { return &cfg.GuardTurnRate })},
+	{"radio-range", "how far a guard's call for help reaches", float32Setter(func(cfg *config) *float32 { return &cfg.RadioRange })},
+	{"kill-radius", "how close a chasing guard must get to catch a player", float32Setter(func(cfg *config) *float32 { return &cfg.KillRadius })},
+	{"pickup-radius", "how far a player can reach for items", float32Setter(func(cfg *config) *float32 { return &cfg.PickupRadius })},
+	{"coin-radius", "radius of a coin", float32Setter(func(cfg *config) *float32 { return &cfg.CoinRadius })},
+	{"view-radius", "how far from a player other entities are sent to it", float32Setter(func(cfg *config) *float32 { return &cfg.ViewRadius })},
+	{"send-queue-length", "messages a client may have waiting before it is disconnected", func(cfg *config, value string) error {
+		length, err := strconv.Atoi(value)
+		cfg.SendQueueLength = length
+		return err
+	}},
+	{"slow-client-timeout", "how long a client may fall behind on snapshots", durationSetter(func(cfg *config) *duration { return &cfg.SlowClientTimeout })},
+}
+
+func durationSetter(field func(cfg *config) *duration) func(cfg *config, value string) error {
+	return func(cfg *config, value string) error {
+		d, err := time.ParseDuration(value)
+		field(cfg).Duration = d
+		return err
+	}
+}
+
+func float32Setter(field func(cfg *config) *float32) func(cfg *config, value string) error {
+	return func(cfg *config, value string) error {
+		f, err := strconv.ParseFloat(value, 32)
+		*field(cfg) = float32(f)
+		return err
+	}
+}
+
+// loadConfig builds the config from defaults, an optional JSON file, the environment and args, in that order
+func loadConfig(args []string) (config, error) {
+	cfg := defaultConfig()
+
+	fs := flag.NewFlagSet("infiltrate", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv(envPrefix+"CONFIG"), "path of a JSON config file")
+	for _, s := range settings {
+		fs.String(s.name, "", s.usage)
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		return cfg, err
+	}
+
+	if *configPath != "" {
+		content, err := os.ReadFile(*configPath)
+		if err != nil {
+			return cfg, err
+		}
+		err = json.Unmarshal(content, &cfg)
+		if err != nil {
+			return cfg, fmt.Errorf("config file %s: %w", *configPath, err)
+		}
+	}
+
+	for _, s := range settings {
+		env := envPrefix + strings.ToUpper(strings.ReplaceAll(s.name, "-", "_"))
+		if value, ok := os.LookupEnv(env); ok {
+			if err := s.apply(&cfg, value); err != nil {
+				return cfg, fmt.Errorf("%s: %w", env, err)
+			}
+		}
+	}
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.name == f.Name && err == nil {
+				if applyErr := s.apply(&cfg, f.Value.String()); applyErr != nil {
+					err = fmt.Errorf("-%s: %w", f.Name, applyErr)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return cfg, err
+	}
+
+	return cfg, cfg.validate()
+}
+
+func (cfg config) validate() error {
+	var errs []error
+	if cfg.Addr == "" {
+		errs = append(errs, errors.New("addr must be set"))
+	}
+	if _, ok := cfg.Maps[cfg.DefaultMap]; !ok {
+		errs = append(errs, fmt.Errorf("default map %q is not in maps", cfg.DefaultMap))
+	}
+	for name, path := range cfg.Maps {
+		if _, err := os.Stat(path); err != nil {
+			errs = append(errs, fmt.Errorf("map %q: %w", name, err))
+		}
+	}
+	if _, err := loadBrains(cfg.BrainDir); err != nil {
+		errs = append(errs, fmt.Errorf("brainDir: %w", err))
+	}
+	for name, d := range map[string]duration{
+		"thinkInterval":       cfg.ThinkInterval,
+		"coinRespawnInterval": cfg.CoinRespawnInterval,
+		"roomIdleTimeout":     cfg.RoomIdleTimeout,
+		"slowClientTimeout":   cfg.SlowClientTimeout,
+		"suspicionTime":       cfg.SuspicionTime,
+		"investigateTime":     cfg.InvestigateTime,
+		"radioCooldown":       cfg.RadioCooldown,
+		"alertDecay":          cfg.AlertDecay,
+		"cameraDowntime":      cfg.CameraDowntime,
+		"stuckTime":           cfg.StuckTime,
+	} {
+		if d.Duration <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive", name))
+		}
+	}
+	for name, f := range map[string]float32{
+		"guardSpeed":    cfg.GuardSpeed,
+		"guardTurnRate": cfg.GuardTurnRate,
+		"radioRange":    cfg.RadioRange,
+		"killRadius":    cfg.KillRadius,
+		"pickupRadius":  cfg.PickupRadius,
+		"coinRadius":    cfg.CoinRadius,
+		"viewRadius":    cfg.ViewRadius,
+	} {
+		if f <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive", name))
+		}
+	}
+	if cfg.TickRate < 1 || cfg.TickRate > 1000 {
+		errs = append(errs, errors.New("tickRate must be between 1 and 1000"))
+	}
+	if cfg.MaxRooms < 1 {
+		errs = append(errs, errors.New("maxRooms must be at least 1"))
+	}
+	if cfg.SendQueueLength < 1 {
+		errs = append(errs, errors.New("sendQueueLength must be at least 1"))
+	}
+	if cfg.PlanWorkers < 1 {
+		errs = append(errs, errors.New("planWorkers must be at least 1"))
+	}
+	if cfg.PlanDeadline < 1 {
+		errs = append(errs, errors.New("planDeadline must be at least 1"))
+	}
+	return errors.Join(errs...)
+}
+
+// A duration is a time.Duration that reads and writes JSON as a string like "200ms"
+type duration struct {
+	time.Duration
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return err
+	}
+	d.Duration, err = time.ParseDuration(s)
+	return err
+}
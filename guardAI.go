@@ -1,105 +1,103 @@
 package main
 
 import (
-	"container/heap"
-	"errors"
-	"log"
+	"context"
 	"math"
 	"math/rand/v2"
-	"time"
 )
 
-func think(g *guard, m model) []action {
-	if g.chasing == nil { // Guard is patrolling
-		g.Searching = true
-		if goalReached(g) {
-			g.currentPoint = (g.currentPoint + 1) % len(g.patrolPoints)
-			g.goal = g.patrolPoints[g.currentPoint]
-		}
-	} else if canSee(g, g.chasing, m) { // Guard is in pursuit
-		g.Searching = false
-		g.goal = state{
-			x: g.chasing.X,
-			y: g.chasing.Y,
-		}
-	} else { // Guard is in pursuit but has lost sight
-		g.Searching = true
-		if goalReached(g) {
-			g.chasing = nil
-		}
-	}
-	currentState := state{
-		x: g.X,
-		y: g.Y,
+// How far and how wide a standard guard sees
+const visionRange = 250
+const visionAngle = math.Pi / 4 // full width of the vision cone, in radians
+
+// think decides where g goes next and plans the actions to get there, with the brain of its
+// archetype. It runs on a copy of the guard outside the simulation. The simulation moves the guard
+// between alert states; think only picks goals for the state it is in.
+func think(ctx context.Context, g *guard, m model, rng *rand.Rand) []action {
+	th := &thought{ctx: ctx, g: g, m: m, rng: rng}
+	_, g.thinking = m.brains[g.kind.Brain].Tick(th, g.board)
+	if ctx.Err() != nil {
+		return nil // the plan was called off and will be thrown away
 	}
-	actions, err := aStar(currentState, g.goal, m)
-	lost := false
-	if err != nil {
-		log.Println(g.Id, "AI error:", err)
-		g.failedPathAttempts++
-		if time.Since(g.lastSuccessfulPathTime) > 1*time.Minute || time.Since(g.lastSuccessfulMoveTime) > 1*time.Minute {
-			g.currentPoint = 0
-			g.goal = g.patrolPoints[0]
-			g.X = g.patrolPoints[0].x
-			g.Y = g.patrolPoints[0].y
-			log.Println("got stuck, tping to spawn:", g.goal)
-		} else if g.failedPathAttempts > len(g.patrolPoints) {
-			lost = true
-			g.currentPoint = closestPatrolPoint(g)
-			xDist := g.patrolPoints[g.currentPoint].x - g.X
-			yDist := g.patrolPoints[g.currentPoint].y - g.Y
-			g.goal = state{
-				x: (g.X + rand.Float32()*xDist) + (rand.Float32()*xDist/4 - xDist/8),
-				y: (g.Y + rand.Float32()*yDist) + (rand.Float32()*yDist/4 - yDist/8),
-			}
-			log.Println("Trying random point: ", g.goal)
-		} else {
-			g.chasing = nil
-			g.currentPoint = (g.currentPoint + 1) % len(g.patrolPoints)
-			g.goal = g.patrolPoints[g.currentPoint]
-			log.Println("Trying patrol point: ", g.goal)
+	return th.actions
+}
+
+// planRoute finds the way from g to its goal, reusing what it can: the path of a patrol leg found
+// when the map loaded, or while chasing the route g was already on with only its end redone
+func planRoute(ctx context.Context, g *guard, m model) ([]state, error) {
+	at := state{x: g.X, y: g.Y}
+	if g.Alert == alertPatrol && len(g.patrolPoints) > 0 && g.goal == g.patrolPoints[g.currentPoint] {
+		previous := g.patrolPoints[(g.currentPoint+len(g.patrolPoints)-1)%len(g.patrolPoints)]
+		if route, ok := m.routes.walk(m.nav, at, leg{previous, g.goal}); ok {
+			return route, nil
+		}
+	} else if g.Alert == alertChase {
+		if route, ok := m.nav.repair(ctx, at, g.route, g.goal); ok {
+			return route, nil
 		}
-		actions = make([]action, 0)
-	} else {
-		g.failedPathAttempts = 0
-	}
-	if !lost {
-		g.lastSuccessfulPathTime = time.Now()
 	}
-	return actions
+	return m.nav.findPath(ctx, at, g.goal)
 }
 
+// How close a guard has to get to its goal to be done with it, a couple of navigation cells
+const goalReachedDistance = 2 * navCellSize
+
+// goalReached reports whether g is at its goal, or as near it as its route gets when the goal is
+// somewhere a guard can't stand, like a patrol point drawn too close to a wall
 func goalReached(g *guard) bool {
-	leniency := float32(guardSpeed * skipFactor)
-	return (g.X-g.goal.x)*(g.X-g.goal.x)+(g.Y-g.goal.y)*(g.Y-g.goal.y) < leniency*leniency
+	at := state{x: g.X, y: g.Y}
+	if at.distanceTo(g.goal) < goalReachedDistance {
+		return true
+	}
+	return len(g.route) > 0 && at.distanceTo(g.route[len(g.route)-1]) < goalReachedDistance
 }
 
-func canSee(g *guard, p *player, m model) bool {
-	x1, y1, x2, y2 := g.X, g.Y, p.X, p.Y
-	if x1 > x2 {
-		x1, y1, x2, y2 = x2, y2, x1, y1
+// inVisionCone reports whether target is within range of g and inside its field of view.
+// It does not check for obstacles, see canSee for that.
+func inVisionCone(g *guard, target state) bool {
+	deltaX := float64(target.x - g.X)
+	deltaY := float64(target.y - g.Y)
+	distance := math.Sqrt(deltaX*deltaX + deltaY*deltaY)
+	if distance > float64(g.kind.SightRange) {
+		return false
 	}
-	if x2-x1 <= 75 {
-		mid := (x1 + x2) / 2
-		x2 = mid + 25
-		x1 = mid - 25
+	if distance == 0 {
+		return true
 	}
+	// A rotation of 0 faces north (negative y), matching how the client draws guards
+	facingX := math.Sin(float64(g.Rotation))
+	facingY := -math.Cos(float64(g.Rotation))
+	cosAngle := (deltaX*facingX + deltaY*facingY) / distance
+	return cosAngle >= math.Cos(float64(g.kind.SightAngle)/2)
+}
 
-	slope := (y2 - y1) / (x2 - x1)
-	b := y1 - slope*x1
+// How far apart canSee looks for obstacles along a line of sight
+const sightStep = 5
 
-	for x := x1; x <= x2; x += 5 {
-		y := (slope * x) + b
+// canSee reports whether no obstacle blocks the line of sight from g to target, whichever way g faces
+func canSee(g *guard, target state, m model) bool {
+	from := state{x: g.X, y: g.Y}
+	samples := int(from.distanceTo(target)/sightStep) + 1
+	for i := 0; i <= samples; i++ {
+		t := float32(i) / float32(samples)
+		x, y := from.x+(target.x-from.x)*t, from.y+(target.y-from.y)*t
 		for _, obs := range m.obstacles {
 			if obs.X < x && obs.X+obs.Width > x && obs.Y < y && obs.Y+obs.Height > y {
 				return false
 			}
 		}
 	}
-
 	return true
 }
 
+// turnToward turns rotation toward heading the short way round, by at most limit,
+// and returns it in the range -π to π
+func turnToward(rotation, heading, limit float32) float32 {
+	turn := float32(math.Remainder(float64(heading-rotation), 2*math.Pi))
+	turn = max(-limit, min(limit, turn))
+	return float32(math.Remainder(float64(rotation+turn), 2*math.Pi))
+}
+
 func closestPatrolPoint(g *guard) int {
 	closest := 0
 	currentState := state{
@@ -116,56 +114,3 @@ func closestPatrolPoint(g *guard) int {
 	}
 	return closest
 }
-
-func aStar(state0 state, goal_state state, m model) ([]action, error) {
-	var startTime = time.Now()
-	var goal_node *node = nil
-	stored_states := make(map[state]bool)
-	node0 := &node{
-		state:          state0,
-		parent:         nil,
-		action:         action{},
-		depth:          0,
-		cost:           0,
-		estimated_cost: m.heuristic(state0, goal_state),
-	}
-	pq := make(PriorityQueue, 0)
-	heap.Push(&pq, node0)
-	for pq.Len() != 0 {
-		current := heap.Pop(&pq).(*node)
-
-		if stored_states[current.state] {
-			continue
-		}
-		stored_states[current.state] = true
-		if current.depth > 150 {
-			return nil, errors.New("depth limit reached")
-		}
-		if time.Since(startTime).Seconds() > 2 {
-			return nil, errors.New("time limit reached")
-		}
-
-		if math.Abs(float64(current.state.distanceTo(goal_state))) < float64(skipFactor*guardSpeed) {
-			goal_node = current
-			break
-		}
-		for _, action := range m.actions(current.state) {
-			result_state := m.result(current.state, action)
-			result_cost := m.step_cost(current.state, action, result_state) + current.cost
-			result_hueristic := m.heuristic(result_state, goal_state)
-			result_node := &node{
-				state:          result_state,
-				parent:         current,
-				action:         action,
-				depth:          current.depth + 1,
-				cost:           result_cost,
-				estimated_cost: result_hueristic,
-			}
-			heap.Push(&pq, result_node)
-		}
-	}
-	if goal_node == nil {
-		return nil, errors.New("no path found")
-	}
-	return goal_node.create_action_sequence(), nil
-}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"math/rand/v2"
+	"testing"
+	"time"
+)
+
+// A simulation is a hub driven by hand instead of started: its clock moves exactly one tick per
+// step, its randomness comes from a fixed seed and every guard's plan is finished before the next
+// tick, so the same map, seed and scripts always play out the same way.
+type simulation struct {
+	t       *testing.T
+	hub     *Hub
+	clock   time.Time
+	clients []*fakeClient
+}
+
+// A fakeClient stands in for a browser. It sends one scripted input per tick, standing still
+// once the script runs out, and keeps everything the hub sends it.
+type fakeClient struct {
+	client   *Client
+	script   []input
+	seq      int
+	received []response
+}
+
+func newSimulation(t *testing.T, mapData string, seed uint64) *simulation {
+	t.Helper()
+	hub, err := newHubFromMap(defaultConfig(), []byte(mapData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim := &simulation{
+		t:     t,
+		hub:   hub,
+		clock: time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC),
+	}
+	sim.hub.now = func() time.Time { return sim.clock }
+	sim.hub.rng = rand.New(rand.NewPCG(seed, 0))
+	t.Cleanup(sim.hub.stop) // ends its plan workers
+	return sim
+}
+
+// standardBrains loads the brains guards think with unless a test says otherwise
+func standardBrains(t *testing.T) brainSet {
+	t.Helper()
+	brains, err := loadBrains(defaultConfig().BrainDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return brains
+}
+
+func (sim *simulation) join(username string) *fakeClient {
+	fc := &fakeClient{client: &Client{
+		hub:   sim.hub,
+		queue: newSendQueue(sim.hub.cfg.SendQueueLength, time.Hour),
+	}}
+	joinRequest{client: fc.client, username: username, maxPlayers: maxMaxPlayers}.Handle(sim.hub)
+	sim.clients = append(sim.clients, fc)
+	return fc
+}
+
+func (sim *simulation) leave(fc *fakeClient) {
+	leaveRequest{client: fc.client}.Handle(sim.hub)
+	for i := range sim.clients {
+		if sim.clients[i] == fc {
+			sim.clients = append(sim.clients[:i], sim.clients[i+1:]...)
+			break
+		}
+	}
+}
+
+// run advances the world ticks times. Each tick every client sends its next input,
+// then the clock moves on, the hub steps and its guards finish planning.
+func (sim *simulation) run(ticks int) {
+	for range ticks {
+		for _, fc := range sim.clients {
+			fc.sendNext(sim.hub)
+		}
+		sim.clock = sim.clock.Add(time.Second / time.Duration(sim.hub.cfg.TickRate))
+		sim.hub.step()
+		sim.hub.awaitPlans()
+		for _, fc := range sim.clients {
+			fc.collect()
+		}
+	}
+}
+
+func (sim *simulation) player(fc *fakeClient) *player {
+	p, ok := sim.hub.players[fc.client]
+	if !ok {
+		sim.t.Fatal("client is not in the hub")
+	}
+	return p
+}
+
+func (sim *simulation) guard(id string) *guard {
+	for i := range sim.hub.guards {
+		if sim.hub.guards[i].Id == id {
+			return &sim.hub.guards[i]
+		}
+	}
+	sim.t.Fatalf("no guard %q", id)
+	return nil
+}
+
+// place puts fc's player at (x, y) as if it had spawned there
+func (sim *simulation) place(fc *fakeClient, x, y float32) {
+	p := sim.player(fc)
+	p.X, p.Y = x, y
+	p.trail = p.trail[:0]
+}
+
+// walk scripts ticks inputs moving in direction (dirX, dirY)
+func (fc *fakeClient) walk(dirX, dirY float32, ticks int) {
+	for range ticks {
+		fc.script = append(fc.script, input{dirX: dirX, dirY: dirY})
+	}
+}
+
+// sprint scripts ticks inputs sprinting in direction (dirX, dirY)
+func (fc *fakeClient) sprint(dirX, dirY float32, ticks int) {
+	for range ticks {
+		fc.script = append(fc.script, input{dirX: dirX, dirY: dirY, sprint: true})
+	}
+}
+
+// sneak scripts ticks inputs sneaking in direction (dirX, dirY)
+func (fc *fakeClient) sneak(dirX, dirY float32, ticks int) {
+	for range ticks {
+		fc.script = append(fc.script, input{dirX: dirX, dirY: dirY, sneak: true})
+	}
+}
+
+// wait scripts ticks inputs standing still
+func (fc *fakeClient) wait(ticks int) {
+	fc.walk(0, 0, ticks)
+}
+
+// grab reaches for itemID right after the last scripted input
+func (fc *fakeClient) grab(itemID string) {
+	if len(fc.script) == 0 {
+		fc.wait(1)
+	}
+	fc.script[len(fc.script)-1].interaction = itemID
+}
+
+func (fc *fakeClient) sendNext(h *Hub) {
+	next := input{}
+	if len(fc.script) > 0 {
+		next = fc.script[0]
+		fc.script = fc.script[1:]
+	}
+	fc.seq++
+	updateRequest{
+		client:      fc.client,
+		Seq:         fc.seq,
+		DirX:        next.dirX,
+		DirY:        next.dirY,
+		Sprint:      next.sprint,
+		Sneak:       next.sneak,
+		Interaction: next.interaction,
+		Ack:         h.tick,
+	}.Handle(h)
+}
+
+// collect takes everything waiting in the client's send queue
+func (fc *fakeClient) collect() {
+	q := fc.client.queue
+	q.Lock()
+	defer q.Unlock()
+	for _, waiting := range q.pending {
+		fc.received = append(fc.received, waiting.message)
+	}
+	q.pending = q.pending[:0]
+}
+
+// scenesReceived counts the scenes with fc's player the hub sent it, as it does on joining and on dying
+func (fc *fakeClient) scenesReceived() int {
+	scenes := 0
+	for _, message := range fc.received {
+		if scene, ok := message.(setSceneResponse); ok && scene.Player.Id != "" {
+			scenes++
+		}
+	}
+	return scenes
+}
@@ -1,53 +1,106 @@
 package main
 
 import (
+	"cmp"
+	"context"
 	"encoding/json"
 	"errors"
 	"log"
 	"math"
+	"math/rand/v2"
 	"os"
-	"sync"
+	"slices"
 	"time"
+
+	"github.com/Aries1542/Infiltrate/behavior"
 )
 
 // The Hub processes requests and updates server data accordingly.
 //
 //	It also continually sends server data to clients
+//
+// All world state belongs to the hub's simulation goroutine (see run), so none of it is locked.
+// Other goroutines reach it only through incoming, and read it with inspect.
 type Hub struct {
-	sync.RWMutex
 	incoming        chan request
+	planRequests    chan planRequest // guards waiting for a planWorker
+	plans           chan plan        // finished guard plans, see planGuards
+	done            chan struct{}    // closed by stop
+	cfg             config
+	now             func() time.Time // the simulation's clock, swapped out by tests
+	rng             *rand.Rand       // all of the simulation's randomness, seeded by tests
 	nextID          int
 	players         map[*Client]*player
+	joined          []*Client // players' clients in the order they joined, so ticks treat them in a fixed order
 	guards          []guard
+	guardTypes      []archetype // of the guards on the map, for clients to draw them by
 	obstacles       []obstacle
 	restrictedAreas []obstacle
 	items           []item
+	itemLayout      []item                  // items as the map places them, restored by respawnCoins
+	kicked          []violationReport       // most recent last
+	tick            int                     // number of the latest simulation step, which stamps its snapshot
+	entityGrid      *spatialGrid[entityRef] // positions as of the latest snapshot
+	nav             *navGrid                // where guards can go, built once from the map
+	routes          patrolRoutes            // every patrol leg's path, also found once
+	brains          brainSet                // read from cfg.BrainDir when the hub was made
+	noises          []noise                 // made by players this tick, heard at the end of it
+	alertLevel      float32                 // how on edge every guard is, from 0 to 1, raised by radio calls
+	scoresChanged   bool
 }
 
 // a player is representation of the data needed to draw one client to another's screen
 type player struct {
-	Id       string  `json:"id"`
-	Username string  `json:"username"`
-	X        float32 `json:"x"`
-	Y        float32 `json:"y"`
-	Rotation float32 `json:"rotation"`
-	Score    int     `json:"score"`
+	Id        string  `json:"id"`
+	Username  string  `json:"username"`
+	X         float32 `json:"x"`
+	Y         float32 `json:"y"`
+	Rotation  float32 `json:"rotation"`
+	Score     int     `json:"score"`
+	LastInput int     `json:"lastInput"` // sequence number of the last input the server simulated
+	inputs    []input
+	trail     []state     // positions after each of the most recent inputs, oldest first
+	flagged   []violation // violations within the last violationWindow
+	kicked    bool
+	acked     int                // newest snapshot the client has confirmed receiving
+	views     []*snapshot        // what the client was sent for each recent snapshot, oldest first
+	interest  map[entityRef]bool // entities in the client's view as of the latest snapshot
 }
 
 type guard struct {
-	Id                     string  `json:"id"`
-	X                      float32 `json:"x"`
-	Y                      float32 `json:"y"`
-	Rotation               float32 `json:"rotation"`
-	Searching              bool    `json:"searching"`
-	actions                []action
-	goal                   state
-	patrolPoints           []state
-	currentPoint           int
-	chasing                *player
-	failedPathAttempts     int // # of patrol points guard cannot navigate to
-	lastSuccessfulPathTime time.Time
-	lastSuccessfulMoveTime time.Time
+	Id              string  `json:"id"`
+	X               float32 `json:"x"`
+	Y               float32 `json:"y"`
+	Rotation        float32 `json:"rotation"`
+	Alert           alert   `json:"alert"`
+	kind            archetype
+	actions         []action
+	route           []state // waypoints the actions walk through, the goal last
+	goal            state
+	patrolPoints    []state
+	currentPoint    int
+	chasing         string              // id of the player being chased, empty unless the alert is alertChase
+	suspect         string              // id of the player a suspicious guard is watching
+	lastKnown       state               // where the guard last saw a player
+	alertedAt       int                 // tick the guard's alert state last changed
+	sweep           int                 // how many points of its search an investigating guard has been to
+	searchHeading   float64             // direction of an investigating guard's first search point, in radians
+	board           behavior.Blackboard // what the guard's brain remembers between thoughts
+	thinking        []string            // path through the guard's brain to what it last decided
+	planning        bool                // whether a plan is being made for the guard
+	cancelPlan      context.CancelFunc  // stops the plan being made
+	planStarted     int                 // tick the plan being made was asked for
+	planStats       planStats
+	revision        int    // bumped by changeMind, making plans in progress stale
+	radioQuietUntil int    // tick from which the guard may radio again
+	pan             *pan   // how a camera looks around, nil for other guards
+	terminal        string // id of the terminal that switches a camera off
+	offlineUntil    int    // tick a camera switched off at its terminal comes back on
+	progress        progress
+	stuck           bool  // whether the simulation found the guard stuck and it has yet to back out
+	velocity        state // how far the guard moved in the latest tick
+	intent          state // how far it would rather have moved, for other guards to steer by
+	drift           state // how far steering has put the guard from where its actions would have it
 }
 
 // An obstacle should be id-less, static, collidable, and rectangular.
@@ -71,132 +124,249 @@ type item struct {
 	Y    float32 `json:"y"`
 }
 
-func newHub() *Hub {
-	obstacles, items, guards, restrictedAreas, err := readWorldData()
+func newHub(cfg config, mapPath string) (*Hub, error) {
+	content, err := os.ReadFile(mapPath)
+	if err != nil {
+		return nil, err
+	}
+	return newHubFromMap(cfg, content)
+}
+
+// newHubFromMap builds a hub for the map file content mapData, with its guards' brains from
+// cfg.BrainDir. Mistakes in the map are logged and left out, but the hub can't do without brains.
+func newHubFromMap(cfg config, mapData []byte) (*Hub, error) {
+	brains, err := loadBrains(cfg.BrainDir)
+	if err != nil {
+		return nil, err
+	}
+	obstacles, items, guards, restrictedAreas, err := readWorldData(mapData, standardGuard(cfg), brains)
 	if err != nil {
 		log.Println(err)
 	}
-	return &Hub{
+	h := &Hub{
 		incoming:        make(chan request),
+		planRequests:    make(chan planRequest, len(guards)), // a guard has at most one plan in progress
+		plans:           make(chan plan, len(guards)),
+		done:            make(chan struct{}),
+		cfg:             cfg,
+		now:             time.Now,
+		rng:             rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())),
 		nextID:          1,
+		entityGrid:      newSpatialGrid[entityRef](cfg.ViewRadius),
 		players:         make(map[*Client]*player),
 		guards:          guards,
 		obstacles:       obstacles,
 		restrictedAreas: restrictedAreas,
-		items:           items,
+		items:           slices.Clone(items),
+		itemLayout:      items,
+		guardTypes:      guardTypes(guards),
+		brains:          brains,
+	}
+	var landmarks []state
+	for _, g := range guards {
+		landmarks = append(landmarks, state{x: g.X, y: g.Y})
+		landmarks = append(landmarks, g.patrolPoints...)
+	}
+	h.nav = newNavGrid(h.model(), landmarks, navRadius(guards))
+	if err := checkPatrols(h.nav, guards); err != nil {
+		log.Println(err)
 	}
+	h.routes = newPatrolRoutes(h.nav, guards)
+	for range cfg.PlanWorkers {
+		go h.planWorker()
+	}
+	return h, nil
 }
 
-func (h *Hub) handleMessages() {
-	for {
-		message := <-h.incoming
-		message.Handle(h)
+// start runs the hub's simulation until stop is called
+func (h *Hub) start() {
+	go h.run()
+}
+
+func (h *Hub) stop() {
+	close(h.done)
+}
+
+// submit hands message to the simulation goroutine, reporting false if the hub has stopped
+func (h *Hub) submit(message request) bool {
+	select {
+	case h.incoming <- message:
+		return true
+	case <-h.done:
+		return false
 	}
 }
 
-func (h *Hub) update() {
-	m := model{
+// inspect runs read on the simulation goroutine and waits for it to finish.
+// It reports false without running read if the hub has stopped.
+func (h *Hub) inspect(read func(h *Hub)) bool {
+	finished := make(chan struct{})
+	if !h.submit(inspection{read: read, finished: finished}) {
+		return false
+	}
+	<-finished
+	return true
+}
+
+// model describes the hub's world for guard and player movement, with speeds converted to distances per tick
+func (h *Hub) model() model {
+	tickRate := float32(h.cfg.TickRate)
+	return model{
 		restrictedAreas: h.restrictedAreas,
 		obstacles:       h.obstacles,
+		tickRate:        tickRate,
+		nav:             h.nav,
+		routes:          h.routes,
+		walkStep:        walkSpeed / tickRate,
+		sprintStep:      sprintSpeed / tickRate,
+		sneakStep:       sneakSpeed / tickRate,
+		bodies:          h.bodies(),
+		brains:          h.brains,
 	}
+}
 
-	updateTicker := time.NewTicker(10 * time.Millisecond)
-	moveTicker := time.NewTicker(20 * time.Millisecond)
-	coinSpawnTicker := time.NewTicker(2 * time.Minute)
+// ticksIn converts d to a whole number of ticks, at least one
+func (h *Hub) ticksIn(d duration) int {
+	return max(1, int(math.Round(d.Seconds()*float64(h.cfg.TickRate))))
+}
+
+// run is the simulation goroutine. Requests are handled between ticks, one at a time.
+func (h *Hub) run() {
+	ticker := time.NewTicker(time.Second / time.Duration(h.cfg.TickRate))
+	defer ticker.Stop()
 	for {
 		select {
-		case <-updateTicker.C:
-			players := make([]player, 0)
-			h.RLock()
-			for client := range h.players {
-				players = append(players, *h.players[client])
-			}
-			for receivingClient := range h.players {
-				receivingClient.outgoing <- updateResponse{Players: players, Guards: h.guards}
-			}
-			h.RUnlock()
-		case <-moveTicker.C:
-			h.Lock()
-			for i := range h.guards {
-				if len(h.guards[i].actions) == 0 {
-					continue
-				}
-				last := len(h.guards[i].actions) - 1
-
-				newX := h.guards[i].X + h.guards[i].actions[last].deltaX
-				newY := h.guards[i].Y + h.guards[i].actions[last].deltaY
-				if m.isValid(state{x: newX, y: newY}) {
-					h.guards[i].X = newX
-					h.guards[i].Y = newY
-					h.guards[i].Rotation = float32(math.Atan2(float64(h.guards[i].actions[last].deltaY), float64(h.guards[i].actions[last].deltaX)) + 0.5*math.Pi)
-					h.guards[i].lastSuccessfulMoveTime = time.Now()
-				}
-				if h.guards[i].chasing != nil {
-					gX := h.guards[i].X
-					gY := h.guards[i].Y
-					pX := h.guards[i].chasing.X
-					pY := h.guards[i].chasing.Y
-					if (state{x: gX, y: gY}).distanceTo(state{x: pX, y: pY}) < 50 {
-						h.killPlayer(&h.guards[i], h.guards[i].chasing)
-					}
-				}
-				if len(h.guards[i].actions) > 0 {
-					h.guards[i].actions = h.guards[i].actions[:last]
-				}
-			}
-			h.Unlock()
-		case <-coinSpawnTicker.C:
-			h.Lock()
-			content, err := os.ReadFile("./mapData.json")
-			if err != nil {
-				log.Fatal("Error when opening file: ", err)
-			}
-			mapData := struct {
-				Items []item
-			}{}
-			err = json.Unmarshal(content, &mapData)
-			if err != nil {
-				log.Println(err)
-			}
-			h.items = mapData.Items
-			for receivingClient := range h.players {
-				receivingClient.outgoing <- setSceneResponse{
-					Items: h.items,
-				}
-			}
-			h.Unlock()
+		case <-h.done:
+			return
+		case message := <-h.incoming:
+			message.Handle(h)
+		case <-ticker.C:
+			h.step()
 		}
 	}
 }
 
-func (h *Hub) handleGuardAI() {
-	thinkTicker := time.NewTicker(200 * time.Millisecond)
-	model := model{
-		restrictedAreas: h.restrictedAreas,
-		obstacles:       h.obstacles,
+// step advances the world by one tick: each player's next input and pickup, then guard movement,
+// kills and detection, then planning and coin respawns when they are due, and finally a snapshot
+// stamped with the tick. Everything that changes the world over time happens here, so tests can
+// drive a hub that was never started by calling step themselves.
+func (h *Hub) step() {
+	m := h.model()
+	h.tick++
+	h.adoptPlans()
+	h.cancelOverduePlans()
+	h.movePlayers(m)
+	h.moveGuards(m)
+	h.checkProgress()
+	h.resolveKills()
+	h.detectPlayers(m)
+	h.hearNoises(m)
+	h.calmDown()
+	if h.tick%h.ticksIn(h.cfg.ThinkInterval) == 0 {
+		h.planGuards(m)
 	}
-	for range thinkTicker.C {
-		for i := range h.guards {
-			if !h.guards[i].Searching || len(h.guards[i].actions) == 0 {
-				actions := think(&h.guards[i], model)
-				h.Lock()
-				h.guards[i].actions = actions
-				h.Unlock()
-			}
+	if h.tick%h.ticksIn(h.cfg.CoinRespawnInterval) == 0 {
+		h.respawnCoins()
+	}
+	h.broadcastSnapshot()
+}
+
+func (h *Hub) movePlayers(m model) {
+	for _, client := range h.joined {
+		p := h.players[client]
+		from := state{x: p.X, y: p.Y}
+		in, ok := p.applyNextInput(m)
+		if !ok {
+			continue
+		}
+		h.moveNoise(p, from, in, m)
+		if in.interaction != "" {
+			h.handleInteraction(in.interaction, client, m)
 		}
 	}
 }
 
-func readWorldData() ([]obstacle, []item, []guard, []obstacle, error) {
-	content, err := os.ReadFile("./mapData.json")
-	if err != nil {
-		log.Fatal("Error when opening file: ", err)
+func (h *Hub) moveGuards(m model) {
+	for i := range h.guards {
+		g := &h.guards[i]
+		switch {
+		case h.offline(g):
+			continue
+		case g.Alert == alertSuspicious || (g.posted() && g.Alert != alertPatrol && g.Alert != alertReturn):
+			// Keeps its eyes on what it noticed, and a guard that can't go after it on where it was
+			heading := float32(math.Atan2(float64(g.lastKnown.y-g.Y), float64(g.lastKnown.x-g.X)) + 0.5*math.Pi)
+			g.Rotation = turnToward(g.Rotation, heading, m.guardTurn(g))
+		case g.pan != nil:
+			g.Rotation = turnToward(g.Rotation, g.pan.heading(h.tick, h.ticksIn(g.pan.period)), m.guardTurn(g))
+		}
+		g.velocity, g.intent = state{}, state{}
+		if len(g.actions) == 0 {
+			g.drift = state{}
+			continue
+		}
+		last := len(g.actions) - 1
+
+		// Head for where the plan has the guard next, which is off the plan's next move by however
+		// far steering has pushed it aside
+		planned := state{x: g.actions[last].deltaX, y: g.actions[last].deltaY}
+		move := h.steer(g, planned.minus(g.drift), m)
+		if move != (state{}) {
+			g.X += move.x
+			g.Y += move.y
+			heading := float32(math.Atan2(float64(move.y), float64(move.x)) + 0.5*math.Pi)
+			g.Rotation = turnToward(g.Rotation, heading, m.guardTurn(g))
+		}
+		g.velocity = move
+		g.drift = g.drift.plus(move).minus(planned)
+		g.actions = g.actions[:last]
+		if g.drift.length() > maxDrift {
+			g.actions = g.actions[:0] // the rest of the walk starts from somewhere else, so plan it again
+		}
+	}
+}
+
+// resolveKills catches every chased player within reach of its guard
+func (h *Hub) resolveKills() {
+	for i := range h.guards {
+		g := &h.guards[i]
+		target := h.playerByID(g.chasing)
+		if target == nil {
+			continue
+		}
+		if (state{x: g.X, y: g.Y}).distanceTo(state{x: target.X, y: target.Y}) < g.kind.KillRange {
+			h.killPlayer(g, target)
+		}
+	}
+}
+
+func (h *Hub) respawnCoins() {
+	h.items = slices.Clone(h.itemLayout) // clients see the coins reappear in their next snapshot
+}
+
+// playerByID returns the player with id, or nil if there is none
+func (h *Hub) playerByID(id string) *player {
+	if id == "" {
+		return nil
 	}
+	for _, p := range h.players {
+		if p.Id == id {
+			return p
+		}
+	}
+	return nil
+}
+
+// readWorldData reads a map file. Guards it doesn't give a type are standard, and the types it
+// gives have to think with one of brains.
+func readWorldData(content []byte, standard archetype, brains brainSet) ([]obstacle, []item, []guard, []obstacle, error) {
 	mapData := struct {
-		Obstacles []obstacle
-		Items     []item
-		Guards    []struct {
+		Obstacles  []obstacle
+		Items      []item
+		GuardTypes []json.RawMessage `json:"guardTypes"`
+		Cameras    []json.RawMessage `json:"cameras"`
+		Guards     []struct {
 			Id           string  `json:"id"`
+			Type         string  `json:"type"`
 			X            float32 `json:"x"`
 			Y            float32 `json:"y"`
 			Rotation     float32 `json:"rotation"`
@@ -207,7 +377,7 @@ func readWorldData() ([]obstacle, []item, []guard, []obstacle, error) {
 		}
 	}{}
 
-	err = json.Unmarshal(content, &mapData)
+	err := json.Unmarshal(content, &mapData)
 	if err != nil {
 		obstacles := make([]obstacle, 0)
 		items := make([]item, 0)
@@ -216,19 +386,28 @@ func readWorldData() ([]obstacle, []item, []guard, []obstacle, error) {
 		return obstacles, items, guards, make([]obstacle, 0), errors.New("could not read file data, continuing with empty world")
 	}
 
+	types, err := readArchetypes(mapData.GuardTypes, standard, brains)
+	if err != nil {
+		log.Println(err)
+	}
 	guards := make([]guard, len(mapData.Guards))
 	for i := range mapData.Guards {
+		kind, found := types[cmp.Or(mapData.Guards[i].Type, standard.Name)]
+		if !found {
+			log.Printf("guard %s has unknown type %q, making it a standard guard", mapData.Guards[i].Id, mapData.Guards[i].Type)
+			kind = standard
+		}
 		guards[i] = guard{
 			Id:           mapData.Guards[i].Id,
+			kind:         kind,
+			board:        make(behavior.Blackboard),
 			X:            mapData.Guards[i].X,
 			Y:            mapData.Guards[i].Y,
 			Rotation:     mapData.Guards[i].Rotation,
-			Searching:    true,
 			actions:      make([]action, 0),
 			goal:         state{x: mapData.Guards[i].X, y: mapData.Guards[i].Y},
 			patrolPoints: make([]state, 0),
 			currentPoint: 0,
-			chasing:      nil,
 		}
 		for j := range mapData.Guards[i].PatrolPoints {
 			guards[i].patrolPoints = append(guards[i].patrolPoints, state{
@@ -238,6 +417,12 @@ func readWorldData() ([]obstacle, []item, []guard, []obstacle, error) {
 		}
 	}
 
+	cameras, errs := readCameras(mapData.Cameras, types)
+	for _, err := range errs {
+		log.Println(err)
+	}
+	guards = append(guards, cameras...)
+
 	restrictedAreas := []obstacle{
 		{
 			X:      -11 * 20,
@@ -252,76 +437,50 @@ func readWorldData() ([]obstacle, []item, []guard, []obstacle, error) {
 	return mapData.Obstacles, mapData.Items, guards, restrictedAreas, nil
 }
 
-func (h *Hub) handleDetection(guardId string, client *Client) {
-	detected := -1
-	h.RLock()
-	for guardIndex := range h.guards {
-		if h.guards[guardIndex].Id == guardId {
-			detected = guardIndex
-			break
-		}
-	}
-	h.RUnlock()
-	if detected == -1 {
-		log.Println("detection requested with invalid id: ", guardId)
-		return
-	}
-	if h.guards[detected].chasing != nil {
-		return
-	}
-	if !canSee(&h.guards[detected], h.players[client], model{h.restrictedAreas, h.obstacles}) {
-		return
-	}
-	h.Lock()
-	h.guards[detected].Searching = false
-	h.guards[detected].chasing = h.players[client]
-	h.guards[detected].goal = state{
-		x: h.players[client].X,
-		y: h.players[client].Y,
-	}
-	h.guards[detected].actions = make([]action, 0)
-	h.Unlock()
-
-}
-
-func (h *Hub) handleInteraction(interactionId string, client *Client) {
+// handleInteraction applies an interaction the client reported right after its latest simulated input
+func (h *Hub) handleInteraction(interactionId string, client *Client, m model) {
 	interacted := -1
-	h.RLock()
 	for itemIndex := range h.items {
 		if h.items[itemIndex].Id == interactionId {
 			interacted = itemIndex
 			break
 		}
 	}
-	h.RUnlock()
 	if interacted == -1 {
 		log.Println("interaction requested with invalid id: ", interactionId)
 		return
 	}
 	switch h.items[interacted].Type {
 	case "coin":
-		playerRadius := float32(50)
-		coinRadius := float32(10)
-		if (state{x: h.items[interacted].X, y: h.items[interacted].Y}).distanceTo(state{x: h.players[client].X, y: h.players[client].Y}) > (playerRadius + coinRadius) {
-			log.Println("interaction requested with invalid distance: ", interactionId)
+		reach := h.cfg.PickupRadius + h.cfg.CoinRadius
+		if err := validatePickup(h.players[client].trail, h.items[interacted], reach, m); err != nil {
+			h.flagViolation(client, "coin "+interactionId+": "+err.Error())
 			return
 		}
-		h.Lock()
 
 		h.items[interacted] = h.items[len(h.items)-1]
 		h.items = h.items[:len(h.items)-1]
 
-		updatingPlayer := h.players[client]
-		updatingPlayer.Score++
-		h.players[client] = updatingPlayer
-		h.Unlock()
+		h.players[client].Score++
+		h.scoresChanged = true
+		h.noises = append(h.noises, noise{at: state{x: h.players[client].X, y: h.players[client].Y}, radius: pickupNoise})
 
-		for eachClient := range h.players {
-			eachClient.outgoing <- removeResponse{
-				Type: "item",
-				Id:   interactionId,
+		// Only clients that can see the coin need to hear about it right away, others never knew it was there
+		for eachClient, p := range h.players {
+			if p.interest[entityRef{kind: itemEntity, id: interactionId}] {
+				eachClient.send(removeResponse{
+					Type: "item",
+					Id:   interactionId,
+				}, queuedCritical)
 			}
 		}
+	case "terminal":
+		reach := h.cfg.PickupRadius + terminalRadius
+		if err := validatePickup(h.players[client].trail, h.items[interacted], reach, m); err != nil {
+			h.flagViolation(client, "terminal "+interactionId+": "+err.Error())
+			return
+		}
+		h.useTerminal(interactionId)
 	}
 }
 
@@ -329,23 +488,38 @@ func (h *Hub) killPlayer(g *guard, p *player) {
 	p.X = 0
 	p.Y = 0
 	p.Score = 0
+	h.scoresChanged = true
+	p.trail = p.trail[:0] // respawning is not part of the player's trajectory
 	for client, player := range h.players {
 		if player == p {
-			client.outgoing <- setSceneResponse{
+			client.send(setSceneResponse{
 				Player: *p,
-			}
+			}, queuedCritical)
 		}
 	}
 
-	g.chasing = nil
-	g.Searching = true
-	g.currentPoint = 0
-	g.goal = g.patrolPoints[0]
+	h.returnToPatrol(g)
+}
+
+// playerCount is safe to call from any goroutine, a stopped hub has no players
+func (h *Hub) playerCount() int {
+	count := 0
+	h.inspect(func(h *Hub) {
+		count = len(h.players)
+	})
+	return count
 }
 
+// usernameExists is safe to call from any goroutine
 func (h *Hub) usernameExists(username string) bool {
-	h.RLock()
-	defer h.RUnlock()
+	exists := false
+	h.inspect(func(h *Hub) {
+		exists = h.usernameTaken(username)
+	})
+	return exists
+}
+
+func (h *Hub) usernameTaken(username string) bool {
 	for _, player := range h.players {
 		if player.Username == username {
 			return true
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestHubUnderLoad drives a hub with scripted clients over real websockets while other goroutines
+// read from it the way the HTTP handlers do. Run it with -race; it mostly checks that nothing
+// outside the simulation goroutine touches world state.
+func TestHubUnderLoad(t *testing.T) {
+	if testing.Short() {
+		t.Skip("load test")
+	}
+	cfg := defaultConfig()
+	cfg.ThinkInterval = duration{50 * time.Millisecond}
+	hub, err := newHub(cfg, "./mapData.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub.start()
+	defer hub.stop()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		attachClient(hub, conn, r.URL.Query().Get("username"), maxMaxPlayers)
+	}))
+	defer server.Close()
+
+	const clients = 12
+	const playTime = 1500 * time.Millisecond
+	var wg sync.WaitGroup
+	stats := make([]loadStats, clients)
+	for i := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			script := loadScript{
+				username: fmt.Sprint("load", i),
+				binary:   i%2 == 0,
+				heading:  float64(i) * 2 * math.Pi / clients,
+				playTime: playTime,
+			}
+			if i%3 == 0 {
+				script.playTime = playTime / 2 // leaves early, possibly while being chased
+			}
+			stats[i] = script.play(t, server.URL)
+		}()
+	}
+
+	stopReading := make(chan struct{})
+	readers := sync.WaitGroup{}
+	readers.Add(1)
+	go func() {
+		defer readers.Done()
+		for {
+			select {
+			case <-stopReading:
+				return
+			default:
+			}
+			hub.playerCount()
+			hub.usernameExists("load1")
+			hub.violationReports()
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	wg.Wait()
+	close(stopReading)
+	readers.Wait()
+
+	for i, s := range stats {
+		if !s.gotScene {
+			t.Errorf("client %d never got its scene", i)
+		}
+		if s.snapshots == 0 {
+			t.Errorf("client %d got no snapshots", i)
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for hub.playerCount() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if count := hub.playerCount(); count != 0 {
+		t.Errorf("%d players still in the hub after every client left", count)
+	}
+}
+
+// A loadScript is one fake player: it walks in a slowly turning circle, sprints now and then,
+// grabs at a coin and acknowledges every snapshot it gets.
+type loadScript struct {
+	username string
+	binary   bool
+	heading  float64
+	playTime time.Duration
+}
+
+type loadStats struct {
+	gotScene  bool
+	snapshots int
+}
+
+func (script loadScript) play(t *testing.T, serverURL string) loadStats {
+	dialer := websocket.Dialer{Subprotocols: []string{jsonSubprotocol}}
+	if script.binary {
+		dialer.Subprotocols = []string{binarySubprotocol}
+	}
+	url := "ws" + strings.TrimPrefix(serverURL, "http") + "?username=" + script.username
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Errorf("%s: %v", script.username, err)
+		return loadStats{}
+	}
+	defer conn.Close()
+
+	var stats loadStats
+	var ack atomic.Int64
+	reading := make(chan struct{})
+	go func() {
+		defer close(reading)
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			requesting, snapshot := describeMessage(t, message, script.binary)
+			switch requesting {
+			case "setScene":
+				stats.gotScene = true
+			case "update":
+				stats.snapshots++
+				ack.Store(int64(snapshot))
+			}
+		}
+	}()
+
+	end := time.Now().Add(script.playTime)
+	for seq := 1; time.Now().Before(end); seq++ {
+		heading := script.heading + float64(seq)/40
+		request := updateRequest{
+			Seq:    seq,
+			DirX:   float32(math.Cos(heading)),
+			DirY:   float32(math.Sin(heading)),
+			Sprint: seq%50 < 10,
+			Ack:    int(ack.Load()),
+		}
+		if seq%25 == 0 {
+			request.Interaction = "coin1"
+		}
+		if script.binary {
+			err = conn.WriteMessage(websocket.BinaryMessage, encodeUpdateRequest(request))
+		} else {
+			err = conn.WriteJSON(struct {
+				Requesting string
+				updateRequest
+			}{"update", request})
+		}
+		if err != nil {
+			t.Errorf("%s: %v", script.username, err)
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	conn.Close()
+	<-reading
+	return stats
+}
+
+// describeMessage returns what a message from the server is and, for updates, its snapshot number
+func describeMessage(t *testing.T, message []byte, binary bool) (string, int) {
+	if binary {
+		decoded, err := decodeResponse(message)
+		if err != nil {
+			t.Error(err)
+			return "", 0
+		}
+		switch decoded := decoded.(type) {
+		case setSceneResponse:
+			return "setScene", 0
+		case updateResponse:
+			return "update", decoded.Tick
+		}
+		return "", 0
+	}
+	var header struct {
+		Requesting string
+		Tick       int
+	}
+	err := json.Unmarshal(message, &header)
+	if err != nil {
+		t.Error(err)
+	}
+	return header.Requesting, header.Tick
+}
+
+func TestStepAdvancesOneTick(t *testing.T) {
+	hub, err := newHub(defaultConfig(), "./mapData.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hub.stop()
+	client := &Client{hub: hub, queue: newSendQueue(64, time.Minute)}
+	joinRequest{client: client, username: "stepper", maxPlayers: maxMaxPlayers}.Handle(hub)
+	updateRequest{client: client, Seq: 1, DirX: 1}.Handle(hub)
+	updateRequest{client: client, Seq: 2, DirX: 1}.Handle(hub)
+
+	hub.step()
+	p := hub.players[client]
+	step := float32(walkSpeed) / float32(hub.cfg.TickRate)
+	if p.X != step || p.LastInput != 1 {
+		t.Errorf("after one tick the player is at x %v having simulated input %d, want x %v and input 1", p.X, p.LastInput, step)
+	}
+	hub.step()
+	if p.X != 2*step || p.LastInput != 2 {
+		t.Errorf("after two ticks the player is at x %v having simulated input %d, want x %v and input 2", p.X, p.LastInput, 2*step)
+	}
+
+	var ticks []int
+	for _, waiting := range client.queue.pending {
+		switch message := waiting.message.(type) {
+		case setSceneResponse:
+			if message.TickRate != hub.cfg.TickRate {
+				t.Errorf("joined with tick rate %d, want %d", message.TickRate, hub.cfg.TickRate)
+			}
+		case encodedResponse:
+			encoded, _ := message.JSONFormat()
+			if requesting, tick := describeMessage(t, encoded, false); requesting == "update" {
+				ticks = append(ticks, tick)
+			}
+		}
+	}
+	// The client never read anything, so only the newest snapshot is still waiting
+	if len(ticks) != 1 || ticks[0] != 2 {
+		t.Errorf("snapshots waiting for the client are stamped %v, want [2]", ticks)
+	}
+}
+
+// Joins that got past the checks before the upgrade together are checked again in the room
+func TestJoinsPastTheChecksAreRefused(t *testing.T) {
+	hub, err := newHubFromMap(defaultConfig(), []byte(coinMap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub.start()
+	defer hub.stop()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		attachClient(hub, conn, r.URL.Query().Get("username"), 2)
+	}))
+	defer server.Close()
+
+	for _, join := range []struct{ username, refusal string }{
+		{"twin", ""},
+		{"twin", "username in use"},
+		{"other", ""},
+		{"third", "room is full"},
+	} {
+		url := "ws" + strings.TrimPrefix(server.URL, "http") + "?username=" + join.username
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		_, _, err = conn.ReadMessage()
+		var closed *websocket.CloseError
+		switch {
+		case join.refusal == "" && err != nil:
+			t.Errorf("%s was refused: %v", join.username, err)
+		case join.refusal != "" && (!errors.As(err, &closed) || closed.Code != closeJoinRefused || closed.Text != join.refusal):
+			t.Errorf("%s got %v, want to be refused with %q", join.username, err, join.refusal)
+		}
+	}
+	if players := hub.playerCount(); players != 2 {
+		t.Errorf("%d players joined a room for 2", players)
+	}
+}
+
+func TestJoiningAClosedRoomIsRefused(t *testing.T) {
+	hub, err := newHubFromMap(defaultConfig(), []byte(coinMap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub.start()
+	hub.stop()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		attachClient(hub, conn, "late", 2)
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second)) // rather than wait forever on a room that never answers
+	_, _, err = conn.ReadMessage()
+	var closed *websocket.CloseError
+	if !errors.As(err, &closed) || closed.Code != closeJoinRefused || closed.Text != "room closed" {
+		t.Errorf("joining a closed room got %v, want to be refused with %q", err, "room closed")
+	}
+}
@@ -1,5 +1,7 @@
 const gridWidth = 1285.5999755859375; // Taken from screen size used to draw map
 const gridHeight = 695.2000122070312; // Taken from screen size used to draw map
+const visionRange = 250; // Matches the server's standard guard, for guards of a type we weren't sent
+const visionAngle = Math.PI / 4;
 
 const drawUI = () => {
     const UI = two.makeGroup();
@@ -96,20 +98,31 @@ const updateGuards = (guards) => {
             continue;
         }
         if (game.guards.children.ids[guard.id] === undefined) {
-            let newGuard = drawGuard(guard.x, guard.y, guard.rotation, guard.id)
+            let newGuard = drawGuard(guard.x, guard.y, guard.rotation, guard.id, guard.alert, guard.type, guard.offline)
             game.guards.add(newGuard);
             continue;
         }
         const drawnGuard = game.guards.children.ids[guard.id]
-        const searchCone = drawnGuard.children.ids["searchCone"]
         drawnGuard.position.set(guard.x, guard.y);
         drawnGuard.rotation = guard.rotation;
-        searchCone.visible = guard.searching;
-        drawnGuard.fill = guard.aggro ? "#b11" : "#d80"
-        searchCone.fill = "#dd0"
+        showAlert(drawnGuard, guard.alert, guard.offline);
     }
 }
 
+// Marks a guard with "?" while it is suspicious or searching, and "!" while it gives chase.
+// A camera switched off at a terminal sees nothing, so it has no cone.
+const showAlert = (drawnGuard, alert, offline=false) => {
+    const searchCone = drawnGuard.children.ids["searchCone"];
+    const indicator = drawnGuard.children.ids["indicator"];
+    const chasing = alert === "chase";
+    searchCone.visible = !chasing && !offline;
+    drawnGuard.fill = chasing ? "#b11" : "#d80";
+    searchCone.fill = alert === "patrol" || alert === "return" ? "#dd0" : "#fa0";
+    indicator.fill = "#000";
+    indicator.value = chasing ? "!" : alert === "suspicious" || alert === "investigate" ? "?" : "";
+    indicator.rotation = -drawnGuard.rotation; // stays upright as the guard turns
+}
+
 const drawPlayer = (x, y, rotation, id, username) => {
     const actor = drawActor(0, 0, rotation, "#6c6");
     actor.id = "actor";
@@ -126,28 +139,40 @@ const drawPlayer = (x, y, rotation, id, username) => {
     return player;
 };
 
-const drawGuard = (x, y, rotation, id) => {
-    const guard = drawActor(x, y, rotation, "#d80", true);
+// Draws a guard as big as its type and with the vision cone it has
+const drawGuard = (x, y, rotation, id, alert, type, offline) => {
+    const {radius = 25, sightRange = visionRange, sightAngle = visionAngle} = game.guardTypes?.[type] || {};
+    const guard = drawActor(x, y, rotation, "#d80", {radius, sightRange, sightAngle});
     guard.id = id;
+    const indicator = two.makeText("", 0, 0, {
+        size: 32,
+        weight: 700,
+        alignment: "center",
+        baseline: "middle",
+    });
+    indicator.id = "indicator";
+    guard.add(indicator);
+    showAlert(guard, alert, offline);
     return guard;
 };
 
-const drawActor = (x, y, rotation, color, guard=false) => {
+// Draws a player, or a guard when given what it is like: its radius and how far and wide it sees
+const drawActor = (x, y, rotation, color, guard=null) => {
     let searchCone = null;
+    const radius = guard ? guard.radius : 25;
     if (guard) {
-        searchCone = two.makePolygon(0, -150, 170, 3);
+        // The sector a guard sees, as the server checks it: its sight range and angle around north
+        searchCone = two.makeArcSegment(0, 0, 0, guard.sightRange, -Math.PI/2 - guard.sightAngle/2, -Math.PI/2 + guard.sightAngle/2);
     }
 
-    const circle = two.makeCircle(0, 0, 25);
-    const triangle = two.makePolygon(0, -25, 20, 3);
-    triangle.height = 30;
+    const circle = two.makeCircle(0, 0, radius);
+    const triangle = two.makePolygon(0, -radius, radius * .8, 3);
+    triangle.height = radius * 1.2;
     const actor = searchCone ? two.makeGroup(searchCone, circle, triangle) : two.makeGroup(circle, triangle);
     actor.fill = color;
     actor.noStroke();
 
     if (searchCone) {
-        searchCone.rotation = Math.PI;
-        searchCone.width = 200;
         searchCone.stroke = "#333";
         searchCone.linewidth = 3;
         searchCone.fill = "#dd0";
@@ -195,6 +220,11 @@ const drawItem = (item) => {
                 return drawCoin(item)
             }
             break;
+        case "terminal":
+            if (!game.items.children.ids[item.id]){
+                return drawTerminal(item)
+            }
+            break;
         default:
             console.log("unknown item type " + item.type + ", skipping draw");
     }
@@ -209,6 +239,17 @@ const drawCoin = (coin) => {
     return circle;
 }
 
+// A terminal switches off the cameras wired to it
+const drawTerminal = (terminal) => {
+    const screen = two.makeRectangle(terminal.x, terminal.y, 30, 20);
+    screen.fill = "#2a4";
+    screen.stroke = "#333";
+    screen.linewidth = 3;
+    screen.type = terminal.type;
+    screen.id = terminal.id;
+    return screen;
+}
+
 const drawGrid = () => {
     const grid = two.makeGroup();
     const adjustedHeight = 1.5*gridHeight - ((1.5*gridHeight)%game.gridSize);
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"log"
 	"net/http"
+	"os"
 )
 
 func noCache(fs http.Handler) http.HandlerFunc {
@@ -15,21 +18,42 @@ func noCache(fs http.Handler) http.HandlerFunc {
 
 func main() {
 
-	hub := newHub()
-	go hub.handleMessages()
-	go hub.update()
-	go hub.handleGuardAI()
+	cfg, err := loadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+
+	rooms, err := newRoomManager(cfg)
+	if err != nil {
+		log.Fatal("Unable to start the public room: ", err)
+	}
+	go rooms.reapIdleRooms()
 
 	http.Handle("/", noCache(http.FileServer(http.Dir("static")))) // serve the static directory to the client
 	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		connectClient(hub, w, r)
+		connectClient(rooms, w, r)
 	})
 	http.HandleFunc("/namecheck", func(w http.ResponseWriter, r *http.Request) {
-		requestUsername(hub, w, r)
+		requestUsername(rooms, w, r)
+	})
+	http.HandleFunc("/rooms", func(w http.ResponseWriter, r *http.Request) {
+		handleRooms(rooms, w, r)
+	})
+	http.HandleFunc("/admin/violations", func(w http.ResponseWriter, r *http.Request) {
+		adminViolations(rooms, w, r)
+	})
+	http.HandleFunc("/admin/planning", func(w http.ResponseWriter, r *http.Request) {
+		adminPlanning(rooms, w, r)
+	})
+	http.HandleFunc("/admin/brains", func(w http.ResponseWriter, r *http.Request) {
+		adminBrains(rooms, w, r)
 	})
 
-	log.Println("Server started!")
-	err := http.ListenAndServe(":8080", nil)
+	log.Println("Server started on", cfg.Addr)
+	err = http.ListenAndServe(cfg.Addr, nil)
 	if err != nil {
 		log.Println("Sever failed: ", err)
 		return
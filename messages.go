@@ -2,6 +2,8 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"slices"
 	"strconv"
 )
 
@@ -10,13 +12,29 @@ type request interface {
 }
 
 type joinRequest struct {
-	client   *Client
-	username string
+	client     *Client
+	username   string
+	maxPlayers int
 }
 
+// Two joins can race past the checks made before the upgrade, so the room's player limit and the
+// names in use are checked again here, and a join that fails is turned away with a close frame.
 func (joining joinRequest) Handle(h *Hub) {
-	h.Lock()
 	client := joining.client
+	reason := ""
+	switch {
+	case len(h.players) >= joining.maxPlayers:
+		reason = "room is full"
+	case h.usernameTaken(joining.username):
+		reason = "username in use"
+	}
+	if reason != "" {
+		go func() {
+			client.disconnect(closeJoinRefused, reason)
+			client.queue.close() // only now, or its writer could hang up before the close frame is out
+		}()
+		return
+	}
 	h.players[client] = &player{
 		Id:       "player" + strconv.Itoa(h.nextID),
 		Username: joining.username,
@@ -26,13 +44,16 @@ func (joining joinRequest) Handle(h *Hub) {
 		Score:    0,
 	}
 	h.nextID++
-	h.Unlock()
-
-	client.outgoing <- setSceneResponse{
-		Player:    *h.players[client],
-		Obstacles: h.obstacles,
-		Items:     h.items,
-	}
+	h.joined = append(h.joined, client)
+	h.scoresChanged = true
+
+	// Items arrive with snapshots, as they come into view
+	client.send(setSceneResponse{
+		Player:     *h.players[client],
+		Obstacles:  h.obstacles,
+		GuardTypes: h.guardTypes,
+		TickRate:   h.cfg.TickRate,
+	}, queuedCritical)
 }
 
 type leaveRequest struct {
@@ -40,94 +61,174 @@ type leaveRequest struct {
 }
 
 func (leaving leaveRequest) Handle(h *Hub) {
-	leavingClientId := h.players[leaving.client].Id
-	h.Lock()
+	leaving.client.queue.close()
+	p, ok := h.players[leaving.client]
+	if !ok {
+		return
+	}
 	delete(h.players, leaving.client)
-	h.Unlock()
-	close(leaving.client.outgoing)
+	h.joined = slices.DeleteFunc(h.joined, func(c *Client) bool { return c == leaving.client })
+	h.scoresChanged = true
+	// Guards after this player search where it was last seen instead
+	for i := range h.guards {
+		if h.guards[i].chasing == p.Id || h.guards[i].suspect == p.Id {
+			h.investigate(&h.guards[i])
+		}
+	}
+	leavingClientId := p.Id
 	for client := range h.players {
-		client.outgoing <- removeResponse{
+		client.send(removeResponse{
 			Type: "player",
 			Id:   leavingClientId,
-		}
+		}, queuedCritical)
 	}
 }
 
+// An updateRequest carries one frame of movement input, not a position.
+// The hub simulates the input itself and echoes Seq back as the player's LastInput.
 type updateRequest struct {
 	client      *Client
-	X           float32
-	Y           float32
-	Rotation    float32
+	Seq         int
+	DirX        float32
+	DirY        float32
+	Sprint      bool
+	Sneak       bool
 	Interaction string
-	DetectedBy  string
+	Ack         int // tick of the newest snapshot the client has applied
 }
 
 func (updating updateRequest) Handle(h *Hub) {
-	h.Lock()
-	updatingPlayer := h.players[updating.client]
-	updatingPlayer.X = updating.X
-	updatingPlayer.Y = updating.Y
-	updatingPlayer.Rotation = updating.Rotation
-	h.players[updating.client] = updatingPlayer
-	h.Unlock()
-	if updating.Interaction != "" {
-		h.handleInteraction(updating.Interaction, updating.client)
+	p, ok := h.players[updating.client]
+	if !ok {
+		return
 	}
-	if updating.DetectedBy != "" {
-		h.handleDetection(updating.DetectedBy, updating.client)
+	err := p.queueInput(input{
+		seq:         updating.Seq,
+		dirX:        updating.DirX,
+		dirY:        updating.DirY,
+		sprint:      updating.Sprint,
+		sneak:       updating.Sneak,
+		interaction: updating.Interaction,
+	})
+	if err != nil {
+		h.flagViolation(updating.client, err.Error())
+	}
+	if updating.Ack > p.acked && updating.Ack <= h.tick {
+		p.acked = updating.Ack
 	}
 }
 
+// An inspection lets code outside the simulation goroutine read world state, see Hub.inspect
+type inspection struct {
+	read     func(h *Hub)
+	finished chan struct{}
+}
+
+func (inspecting inspection) Handle(h *Hub) {
+	inspecting.read(h)
+	close(inspecting.finished)
+}
+
 type response interface {
 	JSONFormat() ([]byte, error)
+	BinaryFormat() ([]byte, error) // see protocol.go
 }
 
 type setSceneResponse struct {
-	Player    player
-	Obstacles []obstacle
-	Items     []item
+	Player     player
+	Obstacles  []obstacle
+	Items      []item
+	GuardTypes []archetype // sent when joining, guards in updates name theirs
+	TickRate   int         // sent when joining, clients send one input per tick
 }
 
 func (response setSceneResponse) JSONFormat() ([]byte, error) {
 	jsonMessage, err := json.Marshal(struct {
-		Requesting string     `json:"requesting"`
-		Player     player     `json:"player"`
-		Obstacles  []obstacle `json:"obstacles"`
-		Items      []item     `json:"items"`
+		Requesting string      `json:"requesting"`
+		Player     player      `json:"player"`
+		Obstacles  []obstacle  `json:"obstacles"`
+		Items      []item      `json:"items"`
+		GuardTypes []archetype `json:"guardTypes,omitempty"`
+		TickRate   int         `json:"tickRate,omitempty"`
 	}{
 		Requesting: "setScene",
 		Player:     response.Player,
 		Obstacles:  response.Obstacles,
 		Items:      response.Items,
+		GuardTypes: response.GuardTypes,
+		TickRate:   response.TickRate,
 	})
 	return jsonMessage, err
 }
 
+// An updateResponse is a world snapshot encoded as changes from an older snapshot the client acknowledged.
+// Snapshots are numbered by the tick they were taken at. A Baseline of 0 means the snapshot is complete.
 type updateResponse struct {
-	Players []player
-	Guards  []guard
+	Tick           int
+	Baseline       int
+	Players        []playerDelta
+	Guards         []guardDelta
+	Items          []item // items never change, so only ones entering view are sent
+	RemovedPlayers []string
+	RemovedGuards  []string
+	RemovedItems   []string
 }
 
 func (response updateResponse) JSONFormat() ([]byte, error) {
-	type clientGuardFormat = struct {
-		guard
-		Aggro bool `json:"aggro"`
-	}
-	guards := make([]clientGuardFormat, 0)
-	for i := range response.Guards {
-		guards = append(guards, clientGuardFormat{
-			guard: response.Guards[i],
-			Aggro: response.Guards[i].chasing != nil,
-		})
+	jsonMessage, err := json.Marshal(struct {
+		Requesting     string        `json:"requesting"`
+		Tick           int           `json:"tick"`
+		Baseline       int           `json:"baseline"`
+		Players        []playerDelta `json:"players"`
+		Guards         []guardDelta  `json:"guards"`
+		Items          []item        `json:"items"`
+		RemovedPlayers []string      `json:"removedPlayers,omitempty"`
+		RemovedGuards  []string      `json:"removedGuards,omitempty"`
+		RemovedItems   []string      `json:"removedItems,omitempty"`
+	}{
+		Requesting:     "update",
+		Tick:           response.Tick,
+		Baseline:       response.Baseline,
+		Players:        response.Players,
+		Guards:         response.Guards,
+		Items:          response.Items,
+		RemovedPlayers: response.RemovedPlayers,
+		RemovedGuards:  response.RemovedGuards,
+		RemovedItems:   response.RemovedItems,
+	})
+	return jsonMessage, err
+}
+
+// An encodedResponse was serialized ahead of time so the same bytes can be sent to many clients.
+// Only the encodings some client needed are filled in.
+type encodedResponse struct {
+	json   []byte
+	binary []byte
+}
+
+func (response encodedResponse) JSONFormat() ([]byte, error) {
+	if response.json == nil {
+		return nil, errors.New("response was not encoded as JSON")
 	}
+	return response.json, nil
+}
+
+type score struct {
+	Username string `json:"username"`
+	Score    int    `json:"score"`
+}
+
+type scoreboardResponse struct {
+	Scores []score
+}
+
+func (response scoreboardResponse) JSONFormat() ([]byte, error) {
 	jsonMessage, err := json.Marshal(struct {
-		Requesting string              `json:"requesting"`
-		Players    []player            `json:"players"`
-		Guards     []clientGuardFormat `json:"guards"`
+		Requesting string  `json:"requesting"`
+		Scores     []score `json:"scores"`
 	}{
-		Requesting: "update",
-		Players:    response.Players,
-		Guards:     guards,
+		Requesting: "scoreboard",
+		Scores:     response.Scores,
 	})
 	return jsonMessage, err
 }
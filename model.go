@@ -1,79 +1,104 @@
 package main
 
-const skipFactor = 12
-const guardSpeed = 2
-
 type model struct {
 	restrictedAreas []obstacle
 	obstacles       []obstacle
+	tickRate        float32 // guards' speeds are per second, see guardStep and guardTurn
+	nav             *navGrid
+	routes          patrolRoutes
+	walkStep        float32 // how far one walking input moves a player
+	sprintStep      float32 // how far one sprinting input moves a player
+	sneakStep       float32 // how far one sneaking input moves a player
+	bodies          []body  // the guards, which unlike the rest move, so only as of when the model was made
+	brains          brainSet
 }
 
-func (m *model) actions(s state) []action {
-	actions := make([]action, 0)
-	var x float32
-	var y float32
-	for x = -1; x <= 1; x++ {
-		for y = -1; y <= 1; y++ {
-			deltaX := x * guardSpeed
-			deltaY := y * guardSpeed
-			if x != 0 && y != 0 {
-				deltaX *= .70710678
-				deltaY *= .70710678
-			}
-			valid := true
-			for i := range skipFactor {
-				newAction := action{deltaX: deltaX * float32(i), deltaY: deltaY * float32(i)}
-				if !m.isValid(m.result(s, newAction)) {
-					valid = false
-					break
-				}
-			}
-			if !valid {
-				continue
-			}
-			actions = append(actions, action{deltaX: deltaX * skipFactor, deltaY: deltaY * skipFactor})
-		}
-	}
-	return actions
+const guardRadius = 25
+
+// guardStep is how far g walks in a tick
+func (m *model) guardStep(g *guard) float32 {
+	return g.kind.Speed / m.tickRate
+}
+
+// guardTurn is how far g can turn in a tick, in radians
+func (m *model) guardTurn(g *guard) float32 {
+	return g.kind.TurnRate / m.tickRate
 }
 
+// isValid reports whether a standard guard could stand at s
 func (m *model) isValid(s state) bool {
-	guardRadius := float32(25)
+	return m.guardFits(s, guardRadius)
+}
 
+// guardFits reports whether a circle of radius at s stays clear of every obstacle and restricted area
+func (m *model) guardFits(s state, radius float32) bool {
 	for _, area := range m.restrictedAreas {
-		closestX := max(area.X, min(s.x, area.X+area.Width))
-		closestY := max(area.Y, min(s.y, area.Y+area.Height))
-		distanceX := s.x - closestX
-		distanceY := s.y - closestY
-		if (distanceX*distanceX + distanceY*distanceY) < (guardRadius * guardRadius) {
+		if circleIntersects(s, radius, area) {
+			return false
+		}
+	}
+
+	for _, obs := range m.obstacles {
+		if circleIntersects(s, radius, obs) {
 			return false
 		}
 	}
+	return true
+}
 
+// playerFits reports whether a player could stand at s. Players collide with
+// obstacles but, unlike guards, are free to enter restricted areas.
+func (m *model) playerFits(s state) bool {
 	for _, obs := range m.obstacles {
-		closestX := max(obs.X, min(s.x, obs.X+obs.Width))
-		closestY := max(obs.Y, min(s.y, obs.Y+obs.Height))
-		distanceX := s.x - closestX
-		distanceY := s.y - closestY
-		if (distanceX*distanceX + distanceY*distanceY) < (guardRadius * guardRadius) {
+		if circleIntersects(s, playerRadius, obs) {
 			return false
 		}
 	}
 	return true
 }
 
-func (m *model) result(s state, a action) state {
-	return state{
-		x: s.x + a.deltaX,
-		y: s.y + a.deltaY,
+// stepFor is how far in moves a player at the pace it asks for. Sprinting wins over sneaking.
+func (m *model) stepFor(in input) float32 {
+	switch {
+	case in.sprint:
+		return m.sprintStep
+	case in.sneak:
+		return m.sneakStep
 	}
+	return m.walkStep
 }
 
-func (m *model) step_cost(s state, a action, rs state) float32 {
-	_, _, _ = s, a, rs
-	return guardSpeed * skipFactor
+// circleIntersects is a circle-vs-rectangle test between a circle centered on s and rect
+func circleIntersects(s state, radius float32, rect obstacle) bool {
+	closestX := max(rect.X, min(s.x, rect.X+rect.Width))
+	closestY := max(rect.Y, min(s.y, rect.Y+rect.Height))
+	distanceX := s.x - closestX
+	distanceY := s.y - closestY
+	return (distanceX*distanceX + distanceY*distanceY) < (radius * radius)
 }
 
-func (m *model) heuristic(s state, gs state) float32 {
-	return s.distanceTo(gs)
+// segmentIntersects reports whether the line segment from a to b passes through rect
+func segmentIntersects(a, b state, rect obstacle) bool {
+	// Clip the segment's parameter range to the rectangle's slab on each axis in turn
+	low, high := float32(0), float32(1)
+	for _, axis := range [2]struct{ start, delta, min, max float32 }{
+		{a.x, b.x - a.x, rect.X, rect.X + rect.Width},
+		{a.y, b.y - a.y, rect.Y, rect.Y + rect.Height},
+	} {
+		if axis.delta == 0 {
+			if axis.start <= axis.min || axis.start >= axis.max {
+				return false
+			}
+			continue
+		}
+		enter, exit := (axis.min-axis.start)/axis.delta, (axis.max-axis.start)/axis.delta
+		if enter > exit {
+			enter, exit = exit, enter
+		}
+		low, high = max(low, enter), min(high, exit)
+		if low >= high {
+			return false
+		}
+	}
+	return true
 }
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"math"
+)
+
+// navCellSize is the spacing of the navigation grid. It must be fine enough that every gap
+// a guard fits through has a cell center the guard could stand on.
+const navCellSize = 10
+
+// How far the grid reaches past the map's obstacles and guards, in cells
+const navMargin = 10
+
+// How far from a blocked point to look for an open cell to stand in for it, in cells
+const navSnapRadius = 8
+
+// navClearance is how much room, beyond its radius, a guard needs at a cell center for the cell to
+// be open. A straight line between open centers can cut up to half a unit into an obstacle's
+// rounded corner, and this keeps such a line walkable.
+const navClearance = 1
+
+// How many cells findPath expands between checks for cancellation
+const navCancelCheck = 256
+
+var errNoPath = errors.New("no path found")
+
+// A navGrid marks every point a guard's center can be, sampled at cell centers. It is built once
+// per map from the obstacles and restricted areas grown by a guard's radius, so searching it
+// never tests geometry again.
+type navGrid struct {
+	originX float32 // center of the top-left cell
+	originY float32
+	cols    int
+	rows    int
+	open    []bool  // row by row
+	region  []int32 // of each open cell, numbering the areas that open cells join up into; -1 when blocked
+}
+
+// newNavGrid builds the grid for guards of radius in m, reaching far enough to cover every point in
+// landmarks as well
+func newNavGrid(m model, landmarks []state, radius float32) *navGrid {
+	minX, minY := float32(math.Inf(1)), float32(math.Inf(1))
+	maxX, maxY := float32(math.Inf(-1)), float32(math.Inf(-1))
+	for _, rect := range append(append([]obstacle{}, m.obstacles...), m.restrictedAreas...) {
+		minX, minY = min(minX, rect.X), min(minY, rect.Y)
+		maxX, maxY = max(maxX, rect.X+rect.Width), max(maxY, rect.Y+rect.Height)
+	}
+	for _, s := range landmarks {
+		minX, minY = min(minX, s.x), min(minY, s.y)
+		maxX, maxY = max(maxX, s.x), max(maxY, s.y)
+	}
+	if minX > maxX {
+		minX, minY, maxX, maxY = 0, 0, 0, 0
+	}
+	n := &navGrid{
+		originX: minX - navMargin*navCellSize,
+		originY: minY - navMargin*navCellSize,
+		cols:    int((maxX-minX)/navCellSize) + 2*navMargin + 1,
+		rows:    int((maxY-minY)/navCellSize) + 2*navMargin + 1,
+	}
+	n.open = make([]bool, n.cols*n.rows)
+	for cell := range n.open {
+		n.open[cell] = m.guardFits(n.center(cell), radius+navClearance)
+	}
+	n.markRegions()
+	return n
+}
+
+// markRegions numbers the areas of open cells, so that a path joins two cells just when they are
+// in the same one
+func (n *navGrid) markRegions() {
+	n.region = make([]int32, len(n.open))
+	for cell := range n.region {
+		n.region[cell] = -1
+	}
+	var next int32
+	var stack []int
+	for cell, open := range n.open {
+		if !open || n.region[cell] >= 0 {
+			continue
+		}
+		n.region[cell] = next
+		stack = append(stack[:0], cell)
+		for len(stack) > 0 {
+			current := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			n.neighbors(current, func(neighbor int, _ float32) {
+				if n.region[neighbor] < 0 {
+					n.region[neighbor] = next
+					stack = append(stack, neighbor)
+				}
+			})
+		}
+		next++
+	}
+}
+
+// regionAt returns the region of the open cell a guard at s would set off from, and false if
+// there is none near enough
+func (n *navGrid) regionAt(s state) (int32, bool) {
+	cell, ok := n.nearestOpen(s)
+	if !ok {
+		return -1, false
+	}
+	return n.region[cell], true
+}
+
+func (n *navGrid) center(cell int) state {
+	return state{
+		x: n.originX + float32(cell%n.cols)*navCellSize,
+		y: n.originY + float32(cell/n.cols)*navCellSize,
+	}
+}
+
+// cellAt returns the cell whose center is nearest s, and false if s is off the grid
+func (n *navGrid) cellAt(s state) (int, bool) {
+	col := int(math.Round(float64((s.x - n.originX) / navCellSize)))
+	row := int(math.Round(float64((s.y - n.originY) / navCellSize)))
+	if col < 0 || row < 0 || col >= n.cols || row >= n.rows {
+		return 0, false
+	}
+	return row*n.cols + col, true
+}
+
+// nearestOpen returns the open cell nearest s within navSnapRadius, for points a guard can't stand on
+// like a player pressed against a wall
+func (n *navGrid) nearestOpen(s state) (int, bool) {
+	col := int(math.Round(float64((s.x - n.originX) / navCellSize)))
+	row := int(math.Round(float64((s.y - n.originY) / navCellSize)))
+	best, bestDistance := -1, float32(math.Inf(1))
+	for r := max(0, row-navSnapRadius); r <= min(n.rows-1, row+navSnapRadius); r++ {
+		for c := max(0, col-navSnapRadius); c <= min(n.cols-1, col+navSnapRadius); c++ {
+			cell := r*n.cols + c
+			if !n.open[cell] {
+				continue
+			}
+			if distance := n.center(cell).distanceTo(s); distance < bestDistance {
+				best, bestDistance = cell, distance
+			}
+		}
+	}
+	return best, best >= 0
+}
+
+// neighbors calls visit for each open cell one step from cell, with the length of the step.
+// Diagonal steps need both cells beside them open, so paths never clip a corner.
+func (n *navGrid) neighbors(cell int, visit func(next int, cost float32)) {
+	col, row := cell%n.cols, cell/n.cols
+	for dy := -1; dy <= 1; dy++ {
+		for dx := -1; dx <= 1; dx++ {
+			if dx == 0 && dy == 0 {
+				continue
+			}
+			c, r := col+dx, row+dy
+			if c < 0 || r < 0 || c >= n.cols || r >= n.rows || !n.open[r*n.cols+c] {
+				continue
+			}
+			cost := float32(navCellSize)
+			if dx != 0 && dy != 0 {
+				if !n.open[row*n.cols+c] || !n.open[r*n.cols+col] {
+					continue
+				}
+				cost *= math.Sqrt2
+			}
+			visit(r*n.cols+c, cost)
+		}
+	}
+}
+
+// findPath searches the grid from the cell nearest from to the cell nearest to, returning the
+// corners a guard at from turns at on the way there. When to is open the path ends exactly at it.
+// It gives up with ctx's error once ctx is done.
+func (n *navGrid) findPath(ctx context.Context, from state, to state) ([]state, error) {
+	start, ok := n.nearestOpen(from)
+	if !ok {
+		return nil, errors.New("start is not on the navigation grid")
+	}
+	goal, ok := n.nearestOpen(to)
+	if !ok {
+		return nil, errors.New("goal is not on the navigation grid")
+	}
+	goalCenter := n.center(goal)
+
+	cost := make([]float32, len(n.open))
+	parent := make([]int32, len(n.open))
+	for i := range cost {
+		cost[i] = float32(math.Inf(1))
+	}
+	cost[start] = 0
+	parent[start] = -1
+	queue := navQueue{{cell: start, estimate: n.center(start).distanceTo(goalCenter)}}
+	for expanded := 1; queue.Len() > 0; expanded++ {
+		if expanded%navCancelCheck == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		current := heap.Pop(&queue).(navNode)
+		if current.cell == goal {
+			break
+		}
+		if current.cost > cost[current.cell] {
+			continue // a shorter way here was found after this entry was queued
+		}
+		n.neighbors(current.cell, func(next int, step float32) {
+			if c := cost[current.cell] + step; c < cost[next] {
+				cost[next] = c
+				parent[next] = int32(current.cell)
+				heap.Push(&queue, navNode{cell: next, cost: c, estimate: c + n.center(next).distanceTo(goalCenter)})
+			}
+		})
+	}
+	if math.IsInf(float64(cost[goal]), 1) {
+		return nil, errNoPath
+	}
+
+	var path []state
+	for cell := goal; cell != start; cell = int(parent[cell]) {
+		path = append(path, n.center(cell))
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	if exact, ok := n.cellAt(to); ok && exact == goal && n.open[goal] {
+		if len(path) == 0 {
+			path = append(path, to)
+		} else {
+			path[len(path)-1] = to
+		}
+	}
+	return n.pull(from, path), nil
+}
+
+// pull straightens a path found cell by cell, dropping every waypoint the guard can walk past in a
+// straight line from the one before, so it only turns at the corners of whatever is in the way
+func (n *navGrid) pull(from state, path []state) []state {
+	pulled := path[:0]
+	anchor := from
+	for i, waypoint := range path {
+		if i+1 < len(path) && n.clear(anchor, path[i+1]) {
+			continue
+		}
+		pulled = append(pulled, waypoint)
+		anchor = waypoint
+	}
+	return pulled
+}
+
+// clear reports whether a guard can walk straight from a to b. Every point along the way has to
+// lie among open cell centers, which the grid's clearance makes enough for the guard to fit there.
+func (n *navGrid) clear(a, b state) bool {
+	au, av := float64(a.x-n.originX)/navCellSize, float64(a.y-n.originY)/navCellSize
+	bu, bv := float64(b.x-n.originX)/navCellSize, float64(b.y-n.originY)/navCellSize
+	du, dv := bu-au, bv-av
+	if !n.among(au, av) || !n.among(bu, bv) {
+		return false
+	}
+	// Between two crossings of the lines joining cell centers the segment stays inside one square
+	// of four centers, so one point from each stretch stands for all of it
+	for t := 0.0; t < 1; {
+		next := min(1, gridCrossing(au, du, t), gridCrossing(av, dv, t))
+		middle := (t + next) / 2
+		if !n.among(au+du*middle, av+dv*middle) {
+			return false
+		}
+		t = next
+	}
+	return true
+}
+
+// among reports whether the cell centers nearest the grid point (u, v) on every side are open.
+// On a line between centers that is the two at either end, and on a center just that one.
+func (n *navGrid) among(u, v float64) bool {
+	for row := int(math.Floor(v)); row <= int(math.Ceil(v)); row++ {
+		for col := int(math.Floor(u)); col <= int(math.Ceil(u)); col++ {
+			if col < 0 || row < 0 || col >= n.cols || row >= n.rows || !n.open[row*n.cols+col] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// gridCrossing returns the first t past after at which start+delta*t is a whole number,
+// or infinity if it never changes
+func gridCrossing(start, delta, after float64) float64 {
+	const nudge = 1e-9 // so a point sitting on a line moves on to the next one
+	if delta == 0 {
+		return math.Inf(1)
+	}
+	at := start + delta*after
+	if delta > 0 {
+		return (math.Floor(at+nudge) + 1 - start) / delta
+	}
+	return (math.Ceil(at-nudge) - 1 - start) / delta
+}
+
+// walkActions turns a path into the moves a guard at from makes to follow it, speed at a time.
+// Like every guard's actions they are in reverse, the next move last.
+func walkActions(from state, path []state, speed float32) []action {
+	var moves []action
+	at := from
+	for _, waypoint := range path {
+		for at != waypoint {
+			distance := at.distanceTo(waypoint)
+			next := waypoint
+			if distance > speed {
+				next = state{
+					x: at.x + (waypoint.x-at.x)*speed/distance,
+					y: at.y + (waypoint.y-at.y)*speed/distance,
+				}
+			}
+			moves = append(moves, action{deltaX: next.x - at.x, deltaY: next.y - at.y})
+			at = next
+		}
+	}
+	for i, j := 0, len(moves)-1; i < j; i, j = i+1, j-1 {
+		moves[i], moves[j] = moves[j], moves[i]
+	}
+	return moves
+}
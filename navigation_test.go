@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+)
+
+func realMapHub(tb testing.TB) *Hub {
+	mapData, err := os.ReadFile("./mapData.json")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	h, err := newHubFromMap(defaultConfig(), mapData)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(h.stop)
+	return h
+}
+
+func TestPatrolPathsStayClear(t *testing.T) {
+	h := realMapHub(t)
+	m := h.model()
+	for _, g := range h.guards {
+		for i, from := range g.patrolPoints {
+			to := g.patrolPoints[(i+1)%len(g.patrolPoints)]
+			path, err := m.nav.findPath(context.Background(), from, to)
+			if err != nil {
+				t.Errorf("%s from %v to %v: %v", g.Id, from, to, err)
+				continue
+			}
+			// Some patrol points are too close to a wall for a guard, which then stops at the nearest open cell
+			near := func(at, point state) bool {
+				return !m.isValid(point) && at.distanceTo(point) <= navSnapRadius*navCellSize
+			}
+			at := from
+			for _, move := range reverse(walkActions(from, path, m.guardStep(&g))) {
+				at = state{x: at.x + move.deltaX, y: at.y + move.deltaY}
+				if !m.isValid(at) && !near(at, from) && !near(at, to) {
+					t.Errorf("%s from %v to %v walks through %v", g.Id, from, to, at)
+					break
+				}
+			}
+			if at != to && !near(at, to) {
+				t.Errorf("%s from %v to %v ends at %v", g.Id, from, to, at)
+			}
+		}
+	}
+}
+
+func TestNoPathIntoWalledOffRoom(t *testing.T) {
+	m := model{obstacles: []obstacle{
+		{X: -100, Y: -100, Width: 200, Height: 10},
+		{X: -100, Y: 90, Width: 200, Height: 10},
+		{X: -100, Y: -100, Width: 10, Height: 200},
+		{X: 90, Y: -100, Width: 10, Height: 200},
+	}}
+	nav := newNavGrid(m, nil, guardRadius)
+	_, err := nav.findPath(context.Background(), state{x: -180, y: 0}, state{x: 0, y: 0})
+	if !errors.Is(err, errNoPath) {
+		t.Errorf("got %v finding a path into a closed room, want %v", err, errNoPath)
+	}
+}
+
+func TestPathsArePulledTight(t *testing.T) {
+	nav := wallModel().nav
+	from, to := state{x: -300, y: 50}, state{x: 300, y: -50}
+	path, err := nav.findPath(context.Background(), from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Round the end of the wall, a couple of turns at each of its corners, then straight to the goal
+	if len(path) > 5 || path[len(path)-1] != to {
+		t.Fatalf("path around a wall is %v, want a few turns and the goal", path)
+	}
+	at := from
+	for _, waypoint := range path {
+		if !nav.clear(at, waypoint) {
+			t.Errorf("path cuts through the wall from %v to %v", at, waypoint)
+		}
+		at = waypoint
+	}
+	if nav.clear(from, to) {
+		t.Error("line straight through the wall is clear")
+	}
+}
+
+func reverse(actions []action) []action {
+	reversed := make([]action, len(actions))
+	for i, a := range actions {
+		reversed[len(actions)-1-i] = a
+	}
+	return reversed
+}
+
+func BenchmarkNavGridBuild(b *testing.B) {
+	h := realMapHub(b)
+	m := h.model()
+	b.ResetTimer()
+	for range b.N {
+		newNavGrid(m, nil, guardRadius)
+	}
+}
+
+// BenchmarkPatrolPaths plans every leg of every guard's patrol on the real map.
+// Before the navigation grid, searching continuous space took about 10s per op and failed 2 legs.
+func BenchmarkPatrolPaths(b *testing.B) {
+	h := realMapHub(b)
+	m := h.model()
+	b.ResetTimer()
+	for range b.N {
+		for _, g := range h.guards {
+			for i, from := range g.patrolPoints {
+				to := g.patrolPoints[(i+1)%len(g.patrolPoints)]
+				path, err := m.nav.findPath(context.Background(), from, to)
+				if err != nil {
+					b.Fatal(err)
+				}
+				walkActions(from, path, m.guardStep(&g))
+			}
+		}
+	}
+}
@@ -16,56 +16,50 @@ func (s state) distanceTo(other state) float32 {
 	return float32(math.Sqrt(float64((s.x-other.x)*(s.x-other.x) + (s.y-other.y)*(s.y-other.y))))
 }
 
-type node struct {
-	index          int
-	state          state
-	parent         *node
-	action         action
-	depth          int
-	cost           float32
-	estimated_cost float32
+// The rest treat a state as a vector, for steering
+
+func (s state) plus(other state) state {
+	return state{x: s.x + other.x, y: s.y + other.y}
 }
 
-func (current node) create_action_sequence() []action {
-	if current.parent == nil {
-		return []action{}
-	} else {
-		actions := []action{}
-		for range skipFactor {
-			actions = append(actions, action{
-				deltaX: current.action.deltaX / skipFactor,
-				deltaY: current.action.deltaY / skipFactor,
-			})
-		}
-		return append(actions, current.parent.create_action_sequence()...)
-	}
+func (s state) minus(other state) state {
+	return state{x: s.x - other.x, y: s.y - other.y}
 }
 
-type PriorityQueue []*node
+func (s state) times(f float32) state {
+	return state{x: s.x * f, y: s.y * f}
+}
 
-func (pq PriorityQueue) Len() int { return len(pq) }
-func (pq PriorityQueue) Less(i, j int) bool {
-	return (pq[i].cost + pq[i].estimated_cost) < (pq[j].cost + pq[j].estimated_cost)
+func (s state) dot(other state) float32 {
+	return s.x*other.x + s.y*other.y
 }
-func (pq PriorityQueue) Swap(i, j int) {
-	pq[i], pq[j] = pq[j], pq[i]
-	pq[i].index = i
-	pq[j].index = j
+
+func (s state) length() float32 {
+	return s.distanceTo(state{})
 }
 
-func (pq *PriorityQueue) Push(x any) {
-	n := len(*pq)
-	node := x.(*node)
-	node.index = n
-	*pq = append(*pq, node)
+// A navNode is a cell queued for expansion in a navGrid search
+type navNode struct {
+	cell     int
+	cost     float32 // of the way to the cell that queued it
+	estimate float32 // cost plus the straight-line distance left to the goal
+}
+
+// A navQueue orders navNodes cheapest estimate first, for container/heap
+type navQueue []navNode
+
+func (q navQueue) Len() int           { return len(q) }
+func (q navQueue) Less(i, j int) bool { return q[i].estimate < q[j].estimate }
+func (q navQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+
+func (q *navQueue) Push(x any) {
+	*q = append(*q, x.(navNode))
 }
 
-func (pq *PriorityQueue) Pop() any {
-	old := *pq
+func (q *navQueue) Pop() any {
+	old := *q
 	n := len(old)
 	node := old[n-1]
-	old[n-1] = nil  // don't stop the GC from reclaiming the item eventually
-	node.index = -1 // for safety
-	*pq = old[0 : n-1]
+	*q = old[:n-1]
 	return node
 }
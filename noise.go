@@ -0,0 +1,98 @@
+package main
+
+// How far each noise a player makes carries in the open. Sneaking makes none.
+const (
+	walkNoise   = 90
+	sprintNoise = 260
+	pickupNoise = 200
+	crateNoise  = 320
+)
+
+// Every obstacle between a noise and a guard cuts how far the noise carries by this factor
+const noiseDamping = 0.5
+
+// The map editor paints crates this color, which is all that tells them from other obstacles
+const crateColor = "#b75"
+
+// A noise is something a player did this tick that guards within earshot hear
+type noise struct {
+	at     state
+	radius float32 // how far it carries in the open
+}
+
+// moveNoise records the noise p made moving from from with in: footsteps at its pace,
+// and a crash if it ran into a crate without sneaking
+func (h *Hub) moveNoise(p *player, from state, in input, m model) {
+	if in.sneak && !in.sprint {
+		return
+	}
+	at := state{x: p.X, y: p.Y}
+	if at != from {
+		radius := float32(walkNoise)
+		if in.sprint {
+			radius = sprintNoise
+		}
+		h.noises = append(h.noises, noise{at: at, radius: radius})
+	}
+	step := m.stepFor(in)
+	intended := state{x: from.x + in.dirX*step, y: from.y + in.dirY*step}
+	if intended != from && m.hitsCrate(intended, playerRadius) {
+		h.noises = append(h.noises, noise{at: at, radius: crateNoise})
+	}
+}
+
+// hearNoises sends every guard that heard one of this tick's noises to investigate the nearest,
+// then forgets them. Guards that are watching or chasing a player ignore what they hear, and
+// a guard already investigating only turns to noises away from where it is searching.
+func (h *Hub) hearNoises(m model) {
+	defer func() { h.noises = h.noises[:0] }()
+	for i := range h.guards {
+		g := &h.guards[i]
+		if g.Alert == alertSuspicious || g.Alert == alertChase {
+			continue
+		}
+		at := state{x: g.X, y: g.Y}
+		var nearest *noise
+		for j := range h.noises {
+			n := &h.noises[j]
+			if g.Alert == alertInvestigate && n.at.distanceTo(g.lastKnown) <= searchRadius {
+				continue
+			}
+			if m.hears(at, noise{at: n.at, radius: n.radius * g.kind.Hearing}) && (nearest == nil || n.at.distanceTo(at) < nearest.at.distanceTo(at)) {
+				nearest = n
+			}
+		}
+		if nearest != nil {
+			g.lastKnown = nearest.at
+			h.investigate(g)
+		}
+	}
+}
+
+// hears reports whether n carries to at, through whatever obstacles are in the way
+func (m *model) hears(at state, n noise) bool {
+	distance := n.at.distanceTo(at)
+	reach := n.radius
+	if distance > reach {
+		return false
+	}
+	for _, obs := range m.obstacles {
+		if segmentIntersects(n.at, at, obs) {
+			reach *= noiseDamping
+			if distance > reach {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// hitsCrate reports whether a circle of radius at s would overlap a crate
+func (m *model) hitsCrate(s state, radius float32) bool {
+	for _, obs := range m.obstacles {
+		if obs.Color == crateColor && circleIntersects(s, radius, obs) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestNoiseIsMuffledByWalls(t *testing.T) {
+	sprint := noise{at: state{x: 0, y: 0}, radius: sprintNoise}
+	open := model{}
+	walled := model{obstacles: []obstacle{{X: -100, Y: 40, Width: 200, Height: 20}}}
+	for _, test := range []struct {
+		name  string
+		m     model
+		at    state
+		hears bool
+	}{
+		{"in the open", open, state{x: 0, y: 200}, true},
+		{"out of earshot", open, state{x: 0, y: 300}, false},
+		{"through a wall", walled, state{x: 0, y: 200}, false},
+		{"close behind a wall", walled, state{x: 0, y: 100}, true},
+		{"around a wall", walled, state{x: 180, y: 50}, true},
+	} {
+		if hears := test.m.hears(test.at, sprint); hears != test.hears {
+			t.Errorf("%s: heard a sprint at %v: %v, want %v", test.name, test.at, hears, test.hears)
+		}
+	}
+}
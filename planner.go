@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// A planRequest is a copy of everything one guard's planner needs, taken so planning can run
+// off the simulation goroutine while the world keeps moving.
+type planRequest struct {
+	ctx      context.Context // cancelled when the plan is no longer wanted
+	model    model
+	index    int
+	revision int
+	guard    guard
+	seed     uint64 // for the planner's randomness, drawn from the hub's so a seeded hub plans the same every run
+}
+
+// A plan is a guard as its planner left it, along with the actions it should take next
+type plan struct {
+	index     int
+	revision  int
+	guard     guard
+	actions   []action
+	cancelled bool          // the planner was stopped before it finished, so the rest means nothing
+	took      time.Duration // how long the planner worked on it
+}
+
+// planStats sums up one guard's plans for the planning metrics
+type planStats struct {
+	Plans      int     `json:"plans"` // adopted or not, counting cancelled ones
+	Cancelled  int     `json:"cancelled"`
+	LastTicks  int     `json:"lastTicks"` // ticks from asking for the latest plan to getting it back
+	MaxTicks   int     `json:"maxTicks"`
+	LastMillis float64 `json:"lastMillis"` // time spent planning the latest plan
+	MaxMillis  float64 `json:"maxMillis"`
+	MeanMillis float64 `json:"meanMillis"`
+}
+
+func (s *planStats) record(finished plan, ticks int) {
+	millis := float64(finished.took) / float64(time.Millisecond)
+	s.Plans++
+	if finished.cancelled {
+		s.Cancelled++
+	}
+	s.LastTicks, s.MaxTicks = ticks, max(s.MaxTicks, ticks)
+	s.LastMillis, s.MaxMillis = millis, max(s.MaxMillis, millis)
+	s.MeanMillis += (millis - s.MeanMillis) / float64(s.Plans)
+}
+
+// planWorker makes plans until the hub stops. Each hub runs cfg.PlanWorkers of them, so a guard
+// whose plan takes long only holds up one worker rather than every other guard.
+func (h *Hub) planWorker() {
+	for {
+		select {
+		case <-h.done:
+			return
+		case req := <-h.planRequests:
+			h.plans <- makePlan(req)
+		}
+	}
+}
+
+// planGuards asks for a plan for every guard that needs new actions and isn't already waiting on one.
+// Plans come back through h.plans and are adopted at the start of a later tick, unless they take
+// longer than cfg.PlanDeadline ticks and are cancelled.
+func (h *Hub) planGuards(m model) {
+	for i := range h.guards {
+		g := &h.guards[i]
+		if g.planning || g.posted() || g.Alert == alertSuspicious || (g.Alert != alertChase && len(g.actions) > 0) {
+			continue
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		g.planning, g.cancelPlan, g.planStarted = true, cancel, h.tick
+		// Never blocks, there is room for one request per guard
+		req := planRequest{ctx: ctx, model: m, index: i, revision: g.revision, guard: *g, seed: h.rng.Uint64()}
+		req.guard.board = maps.Clone(g.board) // the planner's to change
+		h.planRequests <- req
+	}
+}
+
+func makePlan(req planRequest) plan {
+	finished := plan{
+		index:    req.index,
+		revision: req.revision,
+		guard:    req.guard,
+	}
+	if req.ctx.Err() != nil {
+		finished.cancelled = true // called off while waiting for a worker
+		return finished
+	}
+	started := time.Now()
+	finished.actions = think(req.ctx, &finished.guard, req.model, rand.New(rand.NewPCG(req.seed, 0)))
+	finished.cancelled = req.ctx.Err() != nil
+	finished.took = time.Since(started)
+	return finished
+}
+
+// cancelOverduePlans calls off every plan that has run past its deadline. The guard carries on with
+// the actions it has and is planned for again at the next think.
+func (h *Hub) cancelOverduePlans() {
+	for i := range h.guards {
+		g := &h.guards[i]
+		if g.planning && h.tick-g.planStarted >= h.cfg.PlanDeadline {
+			g.cancelPlan()
+		}
+	}
+}
+
+// changeMind makes g's plan in progress stale and stops it, for when the simulation decides something
+// about the guard that its planner couldn't know
+func (g *guard) changeMind() {
+	g.revision++
+	if g.cancelPlan != nil {
+		g.cancelPlan()
+	}
+}
+
+// adoptPlans adopts every plan that has finished since the last tick
+func (h *Hub) adoptPlans() {
+	for {
+		select {
+		case finished := <-h.plans:
+			h.adoptPlan(finished)
+		default:
+			return
+		}
+	}
+}
+
+// awaitPlans waits for every plan in progress and adopts it. Tests use it to make planning
+// finish within the tick it started in, which the real loop only does when planning is fast.
+func (h *Hub) awaitPlans() {
+	for i := range h.guards {
+		for h.guards[i].planning {
+			h.adoptPlan(<-h.plans)
+		}
+	}
+}
+
+// adoptPlan gives a guard the decisions its planner made, unless the plan was cancelled
+// or the simulation changed the guard's mind since
+func (h *Hub) adoptPlan(finished plan) {
+	g := &h.guards[finished.index]
+	g.cancelPlan() // releases the plan's context
+	g.planning, g.cancelPlan = false, nil
+	g.planStats.record(finished, h.tick-g.planStarted)
+	if finished.cancelled || finished.revision != g.revision {
+		return
+	}
+	planned := finished.guard
+	g.Alert = planned.Alert
+	g.goal = planned.goal
+	g.currentPoint = planned.currentPoint
+	g.chasing = planned.chasing
+	g.sweep = planned.sweep
+	g.board = planned.board
+	g.stuck = planned.stuck
+	g.thinking = planned.thinking
+	g.actions = finished.actions
+	g.drift = state{}
+	g.route = planned.route
+}
+
+// A planningReport is one guard's planStats, for the admin endpoint
+type planningReport struct {
+	Room  string `json:"room"`
+	Guard string `json:"guard"`
+	planStats
+}
+
+// planningReports lists every guard's planning metrics. It is safe to call from any goroutine.
+func (h *Hub) planningReports() []planningReport {
+	reports := make([]planningReport, 0)
+	h.inspect(func(h *Hub) {
+		for _, g := range h.guards {
+			reports = append(reports, planningReport{Guard: g.Id, planStats: g.planStats})
+		}
+	})
+	return reports
+}
+
+func (report planningReport) inRoom(id string) planningReport {
+	report.Room = id
+	return report
+}
+
+func adminPlanning(rm *roomManager, w http.ResponseWriter, r *http.Request) {
+	serveReports(rm, w, r, (*Hub).planningReports)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestOverduePlanIsCancelled(t *testing.T) {
+	sim := newSimulation(t, watchtowerMap, 1)
+	sim.run(1)
+	g := sim.guard("guard1")
+	before := *g
+
+	// A plan asked for PlanDeadline ticks ago that still hasn't come back
+	ctx, cancel := context.WithCancel(context.Background())
+	g.planning, g.cancelPlan, g.planStarted = true, cancel, sim.hub.tick-sim.hub.cfg.PlanDeadline
+	sim.hub.cancelOverduePlans()
+	if ctx.Err() == nil {
+		t.Fatal("plan past its deadline was not cancelled")
+	}
+
+	late := makePlan(planRequest{ctx: ctx, model: sim.hub.model(), revision: g.revision, guard: *g, seed: 1})
+	if !late.cancelled {
+		t.Fatal("planner ignored its cancellation")
+	}
+	sim.hub.adoptPlan(late)
+	if g.planning || g.goal != before.goal || len(g.actions) != len(before.actions) {
+		t.Error("guard adopted a cancelled plan")
+	}
+	if g.planStats.Cancelled != 1 || g.planStats.LastTicks != sim.hub.cfg.PlanDeadline {
+		t.Errorf("plan stats are %+v, want one cancelled plan %d ticks late", g.planStats, sim.hub.cfg.PlanDeadline)
+	}
+}
+
+func TestCancelledSearchStops(t *testing.T) {
+	h := realMapHub(t)
+	from, to := h.guards[3].patrolPoints[0], h.guards[10].patrolPoints[0] // across the map
+	if _, err := h.nav.findPath(context.Background(), from, to); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := h.nav.findPath(ctx, from, to)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled search returned %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestPlansAreCounted(t *testing.T) {
+	sim := newSimulation(t, watchtowerMap, 1)
+	sim.run(3 * sim.hub.ticksIn(sim.hub.cfg.ThinkInterval))
+	stats := sim.guard("guard1").planStats
+	if stats.Plans == 0 || stats.Cancelled != 0 {
+		t.Errorf("plan stats are %+v after a few thinks, want some plans and none cancelled", stats)
+	}
+}
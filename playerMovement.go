@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"math"
+)
+
+const playerRadius = 25
+
+// Player speeds in units per second. The client sends one input per tick and the server
+// simulates one per tick, so each input moves a player by the speed over the tick rate.
+const walkSpeed = 130
+const sprintSpeed = 200
+const sneakSpeed = 60
+
+// Inputs beyond this are dropped oldest-first, so flooding the server cannot speed a player up.
+// A network stall or a late tick delivers a burst just as well, so the drop itself is no violation.
+const maxPendingInputs = 10
+
+// An input is a single frame of movement intent from a client.
+// DirX and DirY are a direction, not a position; the server decides where that takes the player.
+type input struct {
+	seq         int
+	dirX        float32
+	dirY        float32
+	sprint      bool
+	sneak       bool   // slower, but silent
+	interaction string // id of an item the client touched after this input, validated once the input is simulated
+}
+
+// queueInput stores an input to be simulated on a later player tick.
+// A non-nil error means the input was not something an honest client sends; the input is still queued, clamped.
+func (p *player) queueInput(in input) error {
+	var err error
+	newest := p.LastInput
+	if len(p.inputs) > 0 {
+		newest = p.inputs[len(p.inputs)-1].seq
+	}
+	if in.seq <= newest {
+		return nil // stale or replayed input
+	}
+	length := float32(math.Sqrt(float64(in.dirX*in.dirX + in.dirY*in.dirY)))
+	if length > 1 {
+		in.dirX /= length
+		in.dirY /= length
+		if length > 1.01 {
+			err = errors.New("input direction longer than 1")
+		}
+	}
+	if len(p.inputs) >= maxPendingInputs {
+		dropped := p.inputs[0]
+		p.inputs = append(p.inputs[1:], in)
+		// The move is lost but not the item touched after it, unless a later input touched one since
+		if survivor := &p.inputs[0]; survivor.interaction == "" {
+			survivor.interaction = dropped.interaction
+		}
+		return err
+	}
+	p.inputs = append(p.inputs, in)
+	return err
+}
+
+// applyNextInput simulates the oldest pending input, if any, against the world geometry in m.
+// It returns the simulated input and false if there was nothing to simulate.
+func (p *player) applyNextInput(m model) (input, bool) {
+	if len(p.inputs) == 0 {
+		return input{}, false
+	}
+	in := p.inputs[0]
+	p.inputs = p.inputs[1:]
+	p.LastInput = in.seq
+	defer p.recordTrail()
+
+	speed := m.stepFor(in)
+	deltaX := in.dirX * speed
+	deltaY := in.dirY * speed
+	if deltaX == 0 && deltaY == 0 {
+		return in, true
+	}
+	p.Rotation = float32(math.Atan2(float64(deltaY), float64(deltaX)) + 0.5*math.Pi)
+
+	// Try the full move first, then slide along whichever axis is still free
+	current := state{x: p.X, y: p.Y}
+	for _, next := range []state{
+		{x: current.x + deltaX, y: current.y + deltaY},
+		{x: current.x + deltaX, y: current.y},
+		{x: current.x, y: current.y + deltaY},
+	} {
+		if next != current && m.playerFits(next) {
+			p.X = next.x
+			p.Y = next.y
+			break
+		}
+	}
+	return in, true
+}
@@ -0,0 +1,539 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Clients pick an encoding with the websocket subprotocol. Clients that ask for neither get JSON,
+// which is also the easiest to read while debugging.
+const binarySubprotocol = "infiltrate.bin"
+const jsonSubprotocol = "infiltrate.json"
+
+// The binary protocol, mirrored by static/protocol.js. Every message starts with one type byte.
+// testdata/protocol.json holds messages encoded here, which static/protocol_test.js decodes.
+//
+//	uvarint   unsigned LEB128, as encoding/binary's AppendUvarint
+//	varint    zig-zag signed LEB128, as encoding/binary's AppendVarint
+//	coord     varint of the value times coordScale
+//	angle     varint of the value times angleScale
+//	string    uvarint byte length, then UTF-8 bytes
+//	id        uvarint n<<1 for ids made of the field's prefix and the number n,
+//	          otherwise uvarint len<<1|1 then the id's bytes
+//	mask      uvarint with bit i set when the i'th optional field follows
+//
+// setScene:  type, flags (1 player, 2 obstacles, 4 items, 8 tick rate, 16 guard types), [player],
+//
+//	[uvarint count, obstacles], [uvarint count, items], [uvarint tick rate], [uvarint count, guard types]
+//
+//	player:   id, string username, coord x, coord y, angle rotation, varint score, uvarint lastInput
+//	obstacle: coord x, coord y, coord width, coord height, string color, string stroke
+//	item:     id, string type, coord x, coord y
+//	guard type: string name, coord speed, angle turnRate, coord radius, coord killRange,
+//	          coord sightRange, angle sightAngle, coord hearing
+//
+// update:    type, uvarint tick, uvarint baseline, uvarint count, players, uvarint count, guards,
+//
+//	uvarint count, items, then removed player, guard and item ids, each as uvarint count, ids
+//	player:   id, mask, then in order username, x, y, rotation, score, lastInput
+//	guard:    id, mask, then in order x, y, rotation, alert (one byte: patrol, suspicious, chase, investigate, return),
+//	          string type, offline (one byte: 0 or 1)
+//	item:     as in setScene
+//
+// remove:    type, string entity type, id
+// scoreboard: type, uvarint count, then for each player string username, varint score
+// update request (client to server): type, uvarint seq, varint dirX*dirScale, varint dirY*dirScale,
+//
+//	flags (1 sprint, 2 sneak), id interaction, uvarint ack
+const (
+	setSceneMessage      byte = 1
+	updateMessage        byte = 2
+	removeMessage        byte = 3
+	scoreboardMessage    byte = 4
+	updateRequestMessage byte = 16
+)
+
+const coordScale = 16
+const angleScale = 1024
+const dirScale = 1024
+
+const playerIDPrefix = "player"
+const guardIDPrefix = "guard"
+const itemIDPrefix = "coin"
+
+var errShortMessage = errors.New("binary message ended early")
+
+func (response setSceneResponse) BinaryFormat() ([]byte, error) {
+	b := []byte{setSceneMessage, 0}
+	if response.Player.Id != "" {
+		b[1] |= 1
+		b = appendPlayer(b, response.Player)
+	}
+	if response.Obstacles != nil {
+		b[1] |= 2
+		b = binary.AppendUvarint(b, uint64(len(response.Obstacles)))
+		for _, obs := range response.Obstacles {
+			b = appendCoord(b, obs.X)
+			b = appendCoord(b, obs.Y)
+			b = appendCoord(b, obs.Width)
+			b = appendCoord(b, obs.Height)
+			b = appendString(b, obs.Color)
+			b = appendString(b, obs.Stroke)
+		}
+	}
+	if response.Items != nil {
+		b[1] |= 4
+		b = appendItems(b, response.Items)
+	}
+	if response.TickRate != 0 {
+		b[1] |= 8
+		b = binary.AppendUvarint(b, uint64(response.TickRate))
+	}
+	if response.GuardTypes != nil {
+		b[1] |= 16
+		b = binary.AppendUvarint(b, uint64(len(response.GuardTypes)))
+		for _, a := range response.GuardTypes {
+			b = appendString(b, a.Name)
+			b = appendCoord(b, a.Speed)
+			b = appendAngle(b, a.TurnRate)
+			b = appendCoord(b, a.Radius)
+			b = appendCoord(b, a.KillRange)
+			b = appendCoord(b, a.SightRange)
+			b = appendAngle(b, a.SightAngle)
+			b = appendCoord(b, a.Hearing)
+		}
+	}
+	return b, nil
+}
+
+func appendItems(b []byte, items []item) []byte {
+	b = binary.AppendUvarint(b, uint64(len(items)))
+	for _, it := range items {
+		b = appendID(b, it.Id, itemIDPrefix)
+		b = appendString(b, it.Type)
+		b = appendCoord(b, it.X)
+		b = appendCoord(b, it.Y)
+	}
+	return b
+}
+
+func appendPlayer(b []byte, p player) []byte {
+	b = appendID(b, p.Id, playerIDPrefix)
+	b = appendString(b, p.Username)
+	b = appendCoord(b, p.X)
+	b = appendCoord(b, p.Y)
+	b = appendAngle(b, p.Rotation)
+	b = binary.AppendVarint(b, int64(p.Score))
+	return binary.AppendUvarint(b, uint64(p.LastInput))
+}
+
+func (response updateResponse) BinaryFormat() ([]byte, error) {
+	b := []byte{updateMessage}
+	b = binary.AppendUvarint(b, uint64(response.Tick))
+	b = binary.AppendUvarint(b, uint64(response.Baseline))
+	b = binary.AppendUvarint(b, uint64(len(response.Players)))
+	for _, p := range response.Players {
+		b = appendID(b, p.Id, playerIDPrefix)
+		b = binary.AppendUvarint(b, playerMask(p))
+		if p.Username != nil {
+			b = appendString(b, *p.Username)
+		}
+		b = appendOptionalCoord(b, p.X)
+		b = appendOptionalCoord(b, p.Y)
+		if p.Rotation != nil {
+			b = appendAngle(b, *p.Rotation)
+		}
+		if p.Score != nil {
+			b = binary.AppendVarint(b, int64(*p.Score))
+		}
+		if p.LastInput != nil {
+			b = binary.AppendUvarint(b, uint64(*p.LastInput))
+		}
+	}
+	b = binary.AppendUvarint(b, uint64(len(response.Guards)))
+	for _, g := range response.Guards {
+		b = appendID(b, g.Id, guardIDPrefix)
+		b = binary.AppendUvarint(b, guardMask(g))
+		b = appendOptionalCoord(b, g.X)
+		b = appendOptionalCoord(b, g.Y)
+		if g.Rotation != nil {
+			b = appendAngle(b, *g.Rotation)
+		}
+		if g.Alert != nil {
+			b = append(b, byte(*g.Alert))
+		}
+		if g.Type != nil {
+			b = appendString(b, *g.Type)
+		}
+		if g.Offline != nil {
+			b = appendBool(b, *g.Offline)
+		}
+	}
+	b = appendItems(b, response.Items)
+	b = appendIDs(b, response.RemovedPlayers, playerIDPrefix)
+	b = appendIDs(b, response.RemovedGuards, guardIDPrefix)
+	b = appendIDs(b, response.RemovedItems, itemIDPrefix)
+	return b, nil
+}
+
+func playerMask(p playerDelta) uint64 {
+	return maskOf(p.Username != nil, p.X != nil, p.Y != nil, p.Rotation != nil, p.Score != nil, p.LastInput != nil)
+}
+
+func guardMask(g guardDelta) uint64 {
+	return maskOf(g.X != nil, g.Y != nil, g.Rotation != nil, g.Alert != nil, g.Type != nil, g.Offline != nil)
+}
+
+func maskOf(present ...bool) uint64 {
+	var mask uint64
+	for i, p := range present {
+		if p {
+			mask |= 1 << i
+		}
+	}
+	return mask
+}
+
+func (response removeResponse) BinaryFormat() ([]byte, error) {
+	b := []byte{removeMessage}
+	b = appendString(b, response.Type)
+	return appendID(b, response.Id, removeIDPrefix(response.Type)), nil
+}
+
+func (response scoreboardResponse) BinaryFormat() ([]byte, error) {
+	b := []byte{scoreboardMessage}
+	b = binary.AppendUvarint(b, uint64(len(response.Scores)))
+	for _, s := range response.Scores {
+		b = appendString(b, s.Username)
+		b = binary.AppendVarint(b, int64(s.Score))
+	}
+	return b, nil
+}
+
+func removeIDPrefix(entityType string) string {
+	if entityType == "player" {
+		return playerIDPrefix
+	}
+	return itemIDPrefix
+}
+
+func (response encodedResponse) BinaryFormat() ([]byte, error) {
+	if response.binary == nil {
+		return nil, errors.New("response was not encoded as binary")
+	}
+	return response.binary, nil
+}
+
+// encodeUpdateRequest is what the client sends; the server only decodes it, but tests and tools need both sides
+func encodeUpdateRequest(updating updateRequest) []byte {
+	b := []byte{updateRequestMessage}
+	b = binary.AppendUvarint(b, uint64(updating.Seq))
+	b = binary.AppendVarint(b, int64(math.Round(float64(updating.DirX)*dirScale)))
+	b = binary.AppendVarint(b, int64(math.Round(float64(updating.DirY)*dirScale)))
+	var flags byte
+	if updating.Sprint {
+		flags |= 1
+	}
+	if updating.Sneak {
+		flags |= 2
+	}
+	b = append(b, flags)
+	b = appendID(b, updating.Interaction, itemIDPrefix)
+	return binary.AppendUvarint(b, uint64(updating.Ack))
+}
+
+func decodeUpdateRequest(message []byte) (updateRequest, error) {
+	d := decoder{b: message}
+	var updating updateRequest
+	if d.byte() != updateRequestMessage {
+		return updating, errors.New("not an update request")
+	}
+	updating.Seq = int(d.uvarint())
+	updating.DirX = float32(float64(d.varint()) / dirScale)
+	updating.DirY = float32(float64(d.varint()) / dirScale)
+	flags := d.byte()
+	updating.Sprint = flags&1 != 0
+	updating.Sneak = flags&2 != 0
+	updating.Interaction = d.id(itemIDPrefix)
+	updating.Ack = int(d.uvarint())
+	return updating, d.err
+}
+
+// decodeResponse reads any message the server sends, in the form it was built before encoding
+func decodeResponse(message []byte) (response, error) {
+	d := decoder{b: message}
+	switch d.byte() {
+	case setSceneMessage:
+		var scene setSceneResponse
+		flags := d.byte()
+		if flags&1 != 0 {
+			scene.Player = d.player()
+		}
+		if flags&2 != 0 {
+			scene.Obstacles = make([]obstacle, d.count())
+			for i := range scene.Obstacles {
+				scene.Obstacles[i] = obstacle{X: d.coord(), Y: d.coord(), Width: d.coord(), Height: d.coord(), Color: d.string(), Stroke: d.string()}
+			}
+		}
+		if flags&4 != 0 {
+			scene.Items = d.items()
+		}
+		if flags&8 != 0 {
+			scene.TickRate = int(d.uvarint())
+		}
+		if flags&16 != 0 {
+			scene.GuardTypes = make([]archetype, d.count())
+			for i := range scene.GuardTypes {
+				scene.GuardTypes[i] = archetype{
+					Name: d.string(), Speed: d.coord(), TurnRate: d.angle(), Radius: d.coord(),
+					KillRange: d.coord(), SightRange: d.coord(), SightAngle: d.angle(), Hearing: d.coord(),
+				}
+			}
+		}
+		return scene, d.err
+	case updateMessage:
+		update := updateResponse{Tick: int(d.uvarint()), Baseline: int(d.uvarint())}
+		update.Players = make([]playerDelta, d.count())
+		for i := range update.Players {
+			p := playerDelta{Id: d.id(playerIDPrefix)}
+			mask := d.uvarint()
+			if mask&(1<<0) != 0 {
+				p.Username = ptr(d.string())
+			}
+			if mask&(1<<1) != 0 {
+				p.X = ptr(d.coord())
+			}
+			if mask&(1<<2) != 0 {
+				p.Y = ptr(d.coord())
+			}
+			if mask&(1<<3) != 0 {
+				p.Rotation = ptr(d.angle())
+			}
+			if mask&(1<<4) != 0 {
+				p.Score = ptr(int(d.varint()))
+			}
+			if mask&(1<<5) != 0 {
+				p.LastInput = ptr(int(d.uvarint()))
+			}
+			update.Players[i] = p
+		}
+		update.Guards = make([]guardDelta, d.count())
+		for i := range update.Guards {
+			g := guardDelta{Id: d.id(guardIDPrefix)}
+			mask := d.uvarint()
+			if mask&(1<<0) != 0 {
+				g.X = ptr(d.coord())
+			}
+			if mask&(1<<1) != 0 {
+				g.Y = ptr(d.coord())
+			}
+			if mask&(1<<2) != 0 {
+				g.Rotation = ptr(d.angle())
+			}
+			if mask&(1<<3) != 0 {
+				g.Alert = ptr(alert(d.byte()))
+			}
+			if mask&(1<<4) != 0 {
+				g.Type = ptr(d.string())
+			}
+			if mask&(1<<5) != 0 {
+				g.Offline = ptr(d.byte() != 0)
+			}
+			update.Guards[i] = g
+		}
+		update.Items = d.items()
+		update.RemovedPlayers = d.ids(playerIDPrefix)
+		update.RemovedGuards = d.ids(guardIDPrefix)
+		update.RemovedItems = d.ids(itemIDPrefix)
+		return update, d.err
+	case removeMessage:
+		remove := removeResponse{Type: d.string()}
+		remove.Id = d.id(removeIDPrefix(remove.Type))
+		return remove, d.err
+	case scoreboardMessage:
+		scores := scoreboardResponse{Scores: make([]score, d.count())}
+		for i := range scores.Scores {
+			scores.Scores[i] = score{Username: d.string(), Score: int(d.varint())}
+		}
+		return scores, d.err
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return nil, errors.New("unknown message type")
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func appendCoord(b []byte, v float32) []byte {
+	return binary.AppendVarint(b, int64(math.Round(float64(v)*coordScale)))
+}
+
+func appendOptionalCoord(b []byte, v *float32) []byte {
+	if v == nil {
+		return b
+	}
+	return appendCoord(b, *v)
+}
+
+func appendAngle(b []byte, v float32) []byte {
+	return binary.AppendVarint(b, int64(math.Round(float64(v)*angleScale)))
+}
+
+func appendBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 1)
+	}
+	return append(b, 0)
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendID(b []byte, id string, prefix string) []byte {
+	if n, ok := idNumber(id, prefix); ok {
+		return binary.AppendUvarint(b, n<<1)
+	}
+	b = binary.AppendUvarint(b, uint64(len(id))<<1|1)
+	return append(b, id...)
+}
+
+func appendIDs(b []byte, ids []string, prefix string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(ids)))
+	for _, id := range ids {
+		b = appendID(b, id, prefix)
+	}
+	return b
+}
+
+// idNumber extracts n from an id spelled exactly prefix+n, so that decoding gives back the same string
+func idNumber(id string, prefix string) (uint64, bool) {
+	digits, ok := strings.CutPrefix(id, prefix)
+	if !ok || digits == "" || (digits[0] == '0' && len(digits) > 1) {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(digits, 10, 62)
+	return n, err == nil
+}
+
+// A decoder reads binary messages field by field, remembering the first error so callers can check once
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) fail() {
+	if d.err == nil {
+		d.err = errShortMessage
+	}
+	d.b = nil
+}
+
+func (d *decoder) byte() byte {
+	if len(d.b) < 1 {
+		d.fail()
+		return 0
+	}
+	v := d.b[0]
+	d.b = d.b[1:]
+	return v
+}
+
+func (d *decoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+// count reads a list length, refusing lengths longer than the rest of the message could hold
+func (d *decoder) count() int {
+	n := d.uvarint()
+	if n > uint64(len(d.b)) {
+		d.fail()
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) bytes(n uint64) []byte {
+	if n > uint64(len(d.b)) {
+		d.fail()
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes(d.uvarint()))
+}
+
+func (d *decoder) id(prefix string) string {
+	v := d.uvarint()
+	if v&1 == 0 {
+		return prefix + strconv.FormatUint(v>>1, 10)
+	}
+	return string(d.bytes(v >> 1))
+}
+
+func (d *decoder) ids(prefix string) []string {
+	n := d.count()
+	if n == 0 {
+		return nil
+	}
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = d.id(prefix)
+	}
+	return ids
+}
+
+func (d *decoder) items() []item {
+	items := make([]item, d.count())
+	for i := range items {
+		items[i] = item{Id: d.id(itemIDPrefix), Type: d.string(), X: d.coord(), Y: d.coord()}
+	}
+	return items
+}
+
+func (d *decoder) coord() float32 {
+	return float32(float64(d.varint()) / coordScale)
+}
+
+func (d *decoder) angle() float32 {
+	return float32(float64(d.varint()) / angleScale)
+}
+
+func (d *decoder) player() player {
+	return player{
+		Id:        d.id(playerIDPrefix),
+		Username:  d.string(),
+		X:         d.coord(),
+		Y:         d.coord(),
+		Rotation:  d.angle(),
+		Score:     int(d.varint()),
+		LastInput: int(d.uvarint()),
+	}
+}
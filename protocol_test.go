@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+// Values in these messages are chosen to be exact in the binary protocol's fixed-point format,
+// so both encodings must give back exactly what went in.
+
+func testResponses() map[string]response {
+	return map[string]response{
+		"setScene": setSceneResponse{
+			Player: player{Id: "player3", Username: "sneaky", X: 12.5, Y: -40.0625, Rotation: 1.5, Score: 7, LastInput: 812},
+			Obstacles: []obstacle{
+				{X: 37.25, Y: 152.375, Width: 200, Height: 20, Color: "#008", Stroke: "none"},
+				{X: -242.75, Y: -127.5, Width: 40, Height: 40, Color: "#b75", Stroke: "#753"},
+			},
+			Items: []item{
+				{Id: "coin6", Type: "coin", X: 422.625, Y: 38.25},
+				{Id: "bonus", Type: "coin", X: -1, Y: 0},
+			},
+			GuardTypes: []archetype{
+				{Name: "guard", Speed: 100, TurnRate: 6.25, Radius: 25, KillRange: 50, SightRange: 250, SightAngle: 0.75, Hearing: 1},
+				{Name: "camera", TurnRate: 0.5, Radius: 10, SightRange: 400, SightAngle: 1.5},
+			},
+			TickRate: 60,
+		},
+		"setScene respawn": setSceneResponse{
+			Items: []item{{Id: "coin10", Type: "coin", X: 5, Y: 6}},
+		},
+		"update full": updateResponse{
+			Tick:     1200,
+			Baseline: 0,
+			Players: []playerDelta{{
+				Id: "player1", Username: ptr("ana"), X: ptr[float32](-3.5), Y: ptr[float32](100),
+				Rotation: ptr[float32](0.25), Score: ptr(2), LastInput: ptr(99),
+			}},
+			Guards: []guardDelta{{
+				Id: "guard11", X: ptr[float32](-888), Y: ptr[float32](64.5),
+				Rotation: ptr[float32](-0.5), Alert: ptr(alertInvestigate), Type: ptr("dog"),
+			}},
+			Items: []item{{Id: "coin6", Type: "coin", X: 422.625, Y: 38.25}},
+		},
+		"update delta": updateResponse{
+			Tick:     1201,
+			Baseline: 1199,
+			Players:  []playerDelta{{Id: "player1", X: ptr[float32](-2), LastInput: ptr(101)}},
+			Guards: []guardDelta{
+				{Id: "guard2", Alert: ptr(alertChase)}, {Id: "odd-guard", Y: ptr[float32](0)},
+				{Id: "camera1", Offline: ptr(true)}, {Id: "camera2", Rotation: ptr[float32](1), Offline: ptr(false)},
+			},
+			Items:          []item{},
+			RemovedPlayers: []string{"player4"},
+			RemovedGuards:  []string{"guard07"},
+			RemovedItems:   []string{"coin9", "coin10"},
+		},
+		"scoreboard":    scoreboardResponse{Scores: []score{{Username: "ana", Score: 12}, {Username: "bob", Score: 0}}},
+		"remove player": removeResponse{Type: "player", Id: "player12"},
+		"remove item":   removeResponse{Type: "item", Id: "coin133"},
+	}
+}
+
+var testUpdateRequests = []updateRequest{
+	{Seq: 1, DirX: 0.75, DirY: -0.5, Sprint: true, Interaction: "coin42", Ack: 1200},
+	{Seq: 100000, DirX: -1, DirY: 0, Sneak: true},
+	{Seq: 3, Interaction: "not-a-coin"},
+}
+
+func TestBinaryResponseRoundTrip(t *testing.T) {
+	for name, original := range testResponses() {
+		encoded, err := original.BinaryFormat()
+		if err != nil {
+			t.Fatalf("%s: encoding: %v", name, err)
+		}
+		decoded, err := decodeResponse(encoded)
+		if err != nil {
+			t.Fatalf("%s: decoding: %v", name, err)
+		}
+		if !reflect.DeepEqual(decoded, original) {
+			t.Errorf("%s: got %+v, want %+v", name, decoded, original)
+		}
+	}
+}
+
+func TestJSONResponseRoundTrip(t *testing.T) {
+	for name, original := range testResponses() {
+		encoded, err := original.JSONFormat()
+		if err != nil {
+			t.Fatalf("%s: encoding: %v", name, err)
+		}
+		decoded := reflect.New(reflect.TypeOf(original))
+		err = json.Unmarshal(encoded, decoded.Interface())
+		if err != nil {
+			t.Fatalf("%s: decoding: %v", name, err)
+		}
+		if !reflect.DeepEqual(decoded.Elem().Interface(), original) {
+			t.Errorf("%s: got %+v, want %+v", name, decoded.Elem().Interface(), original)
+		}
+	}
+}
+
+func TestUpdateRequestRoundTrip(t *testing.T) {
+	for _, original := range testUpdateRequests {
+		decoded, err := decodeUpdateRequest(encodeUpdateRequest(original))
+		if err != nil {
+			t.Fatalf("binary decoding %+v: %v", original, err)
+		}
+		if decoded != original {
+			t.Errorf("binary: got %+v, want %+v", decoded, original)
+		}
+
+		encoded, err := json.Marshal(original)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fromJSON updateRequest
+		err = json.Unmarshal(encoded, &fromJSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fromJSON != original {
+			t.Errorf("json: got %+v, want %+v", fromJSON, original)
+		}
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	for name, original := range testResponses() {
+		encoded, _ := original.BinaryFormat()
+		for cut := 1; cut < len(encoded); cut++ {
+			if _, err := decodeResponse(encoded[:cut]); err == nil {
+				t.Errorf("%s: decoding %d of %d bytes succeeded", name, cut, len(encoded))
+				break
+			}
+		}
+	}
+}
+
+var updateFixture = flag.Bool("update", false, "rewrite testdata/protocol.json from the Go encoders")
+
+// protocolFixture holds messages as Go encodes them, for static/protocol_test.js to check the
+// browser's side of the protocol against
+type protocolFixture struct {
+	Responses      map[string]fixtureMessage `json:"responses"`
+	UpdateRequests []fixtureMessage          `json:"updateRequests"`
+}
+
+type fixtureMessage struct {
+	Binary []byte          `json:"binary"`
+	JSON   json.RawMessage `json:"json"`
+}
+
+func TestProtocolFixtureIsCurrent(t *testing.T) {
+	fixture := protocolFixture{Responses: map[string]fixtureMessage{}}
+	for name, original := range testResponses() {
+		binary, err := original.BinaryFormat()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		encoded, err := original.JSONFormat()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		fixture.Responses[name] = fixtureMessage{Binary: binary, JSON: encoded}
+	}
+	for _, original := range testUpdateRequests {
+		encoded, err := json.Marshal(original)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fixture.UpdateRequests = append(fixture.UpdateRequests, fixtureMessage{Binary: encodeUpdateRequest(original), JSON: encoded})
+	}
+	current, err := json.MarshalIndent(fixture, "", "\t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	current = append(current, '\n')
+
+	const path = "testdata/protocol.json"
+	if *updateFixture {
+		err = os.WriteFile(path, current, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkedIn, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(checkedIn, current) {
+		t.Errorf("%s is out of date with the encoders, run go test -run ProtocolFixture -update", path)
+	}
+}
+
+// The fixture is only worth checking in if the browser decodes it to the same thing
+func TestBrowserReadsProtocolFixture(t *testing.T) {
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node is not installed")
+	}
+	output, err := exec.Command(node, "--test", "static/protocol_test.js").CombinedOutput()
+	if err != nil {
+		t.Errorf("static/protocol_test.js: %v\n%s", err, output)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import "math"
+
+// How far ahead of a player, along the way it faces, guards answering a radio call head to cut it off
+const radioLead = 150
+
+// How much one radio call raises the map's alert level, which runs from 0 to 1
+const radioAlert = 0.5
+
+// radio has g, which has just started chasing p, call in where p is heading to every guard within
+// radio range that can leave its post and isn't busy with a player of its own. They converge there to cut p off.
+// A guard only calls again once cfg.RadioCooldown has passed since its last call.
+func (h *Hub) radio(g *guard, p *player, m model) {
+	if h.tick < g.radioQuietUntil {
+		return
+	}
+	g.radioQuietUntil = h.tick + h.ticksIn(h.cfg.RadioCooldown)
+	h.alertLevel = min(1, h.alertLevel+radioAlert)
+
+	meet := state{
+		x: p.X + radioLead*float32(math.Sin(float64(p.Rotation))),
+		y: p.Y - radioLead*float32(math.Cos(float64(p.Rotation))),
+	}
+	if !m.isValid(meet) {
+		meet = state{x: p.X, y: p.Y} // heading into a wall, so go where it is
+	}
+	for i := range h.guards {
+		other := &h.guards[i]
+		if other == g || other.posted() || other.Alert == alertSuspicious || other.Alert == alertChase {
+			continue
+		}
+		if (state{x: other.X, y: other.Y}).distanceTo(state{x: g.X, y: g.Y}) > h.radioRange() {
+			continue
+		}
+		other.lastKnown = meet
+		h.investigate(other)
+	}
+}
+
+// radioRange is how far a radio call reaches, further the more alert the map is
+func (h *Hub) radioRange() float32 {
+	return h.cfg.RadioRange * (1 + h.alertLevel)
+}
+
+// suspicionTicks is how long a guard watches a player before giving chase. On a fully alert map
+// guards are quicker to act, taking half as long.
+func (h *Hub) suspicionTicks() int {
+	return max(1, int(math.Round(float64(h.ticksIn(h.cfg.SuspicionTime))*float64(1-h.alertLevel/2))))
+}
+
+// calmDown lowers the map's alert level by one tick's worth, so it falls from full to nothing over cfg.AlertDecay
+func (h *Hub) calmDown() {
+	h.alertLevel = max(0, h.alertLevel-1/float32(h.ticksIn(h.cfg.AlertDecay)))
+}
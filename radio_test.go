@@ -0,0 +1,67 @@
+package main
+
+import "testing"
+
+// The watchtower guard, with a second guard patrolling within radio range and a third far out of it
+const radioMap = `{
+	"obstacles": [],
+	"items": [],
+	"guards": [
+		{"id": "guard1", "x": 0, "y": -400, "rotation": 3.14159, "patrolPoints": [{"x": 0, "y": -400}, {"x": 0, "y": -600}]},
+		{"id": "guard2", "x": 500, "y": -400, "rotation": 0, "patrolPoints": [{"x": 500, "y": -400}, {"x": 500, "y": -600}]},
+		{"id": "guard3", "x": 2500, "y": -400, "rotation": 0, "patrolPoints": [{"x": 2500, "y": -400}, {"x": 2500, "y": -600}]}
+	]
+}`
+
+func TestChaseIsRadioedToGuardsInRange(t *testing.T) {
+	sim := newSimulation(t, radioMap, 1)
+	thief := sim.join("thief")
+	sim.place(thief, 0, -250)
+	sim.run(sim.hub.ticksIn(sim.hub.cfg.SuspicionTime) + 1)
+	if g := sim.guard("guard1"); g.Alert != alertChase {
+		t.Fatalf("guard1 is %v, want it chasing", g.Alert)
+	}
+
+	p := sim.player(thief)
+	if g := sim.guard("guard2"); g.Alert != alertInvestigate {
+		t.Errorf("guard2 is %v, want it answering guard1's call", g.Alert)
+	} else if g.lastKnown.distanceTo(state{x: p.X, y: p.Y}) > radioLead {
+		t.Errorf("guard2 is heading for %v, nowhere near the player at (%v, %v)", g.lastKnown, p.X, p.Y)
+	}
+	if g := sim.guard("guard3"); g.Alert != alertPatrol {
+		t.Errorf("guard3 is %v, want it out of radio range and still patrolling", g.Alert)
+	}
+	if sim.hub.alertLevel == 0 {
+		t.Error("the call left the map calm")
+	}
+}
+
+func TestRadioCoolsDownAndAlertDecays(t *testing.T) {
+	sim := newSimulation(t, radioMap, 1)
+	thief := sim.join("thief")
+	sim.place(thief, 0, -250)
+	h := sim.hub
+	caller, other := sim.guard("guard1"), sim.guard("guard2")
+	h.radio(caller, sim.player(thief), h.model())
+	if other.Alert != alertInvestigate {
+		t.Fatalf("guard2 is %v, want it answering the call", other.Alert)
+	}
+	if h.suspicionTicks() >= h.ticksIn(h.cfg.SuspicionTime) {
+		t.Errorf("guards take %d ticks to give chase on an alert map, want fewer than %d", h.suspicionTicks(), h.ticksIn(h.cfg.SuspicionTime))
+	}
+
+	h.returnToPatrol(other)
+	h.radio(caller, sim.player(thief), h.model())
+	if other.Alert != alertReturn {
+		t.Errorf("guard2 is %v, want the second call ignored during the cooldown", other.Alert)
+	}
+
+	sim.leave(thief)
+	sim.run(h.ticksIn(h.cfg.AlertDecay))
+	if h.alertLevel != 0 {
+		t.Errorf("alert level is %v after %v, want the map calm again", h.alertLevel, h.cfg.AlertDecay)
+	}
+	if h.suspicionTicks() != h.ticksIn(h.cfg.SuspicionTime) {
+		t.Errorf("guards take %d ticks to give chase on a calm map, want %d", h.suspicionTicks(), h.ticksIn(h.cfg.SuspicionTime))
+	}
+}
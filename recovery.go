@@ -0,0 +1,194 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/Aries1542/Infiltrate/behavior"
+)
+
+// How far a guard has to get from where it was to be getting anywhere, and how far at least it
+// backs out when it isn't
+const stuckRadius = 2 * goalReachedDistance
+
+// How far around itself a guard looks for somewhere to back out to, in cells
+const escapeReach = 3 * navSnapRadius
+
+// progress is where a guard last got somewhere from, and the tick it was there
+type progress struct {
+	at    state
+	since int
+}
+
+// checkProgress watches every guard that is on its way somewhere. One that hasn't got further than
+// stuckRadius for cfg.StuckTime, bumping into something or turning back and forth between two
+// routes, drops what it was doing and is left to its brain to back out of.
+func (h *Hub) checkProgress() {
+	for i := range h.guards {
+		g := &h.guards[i]
+		at := state{x: g.X, y: g.Y}
+		if !h.underway(g) || at.distanceTo(g.progress.at) >= stuckRadius {
+			g.progress = progress{at: at, since: h.tick}
+			continue
+		}
+		if h.tick-g.progress.since < h.ticksIn(h.cfg.StuckTime) {
+			continue
+		}
+		log.Println(g.Id, "is stuck at", at, "on its way to", g.goal)
+		g.progress = progress{at: at, since: h.tick}
+		g.stuck = true
+		g.actions = g.actions[:0]
+		g.route = nil
+		g.changeMind()
+	}
+}
+
+// underway reports whether g is trying to get somewhere: it can walk, its attention isn't held
+// where it stands and it hasn't reached its goal
+func (h *Hub) underway(g *guard) bool {
+	return !g.posted() && !h.offline(g) && g.Alert != alertSuspicious && !goalReached(g)
+}
+
+// escape backs a guard the simulation found stuck out to open ground. Once there it has reached
+// its goal as far as it can, so it moves on from one it got stuck on the way to.
+func escape(th *thought, _ behavior.Blackboard) behavior.Status {
+	if !backOut(th, stuckRadius) {
+		return behavior.Failure
+	}
+	th.g.stuck = false
+	return behavior.Running
+}
+
+// recoverPath gets a guard that can't find its way going again. It heads for each of its patrol
+// points in turn, and once none of those works either, backs out to open ground further away each
+// time, walking there in sight of anyone watching.
+func recoverPath(th *thought, board behavior.Blackboard) behavior.Status {
+	g := th.g
+	failed := behavior.Get[int](board, failedPathsKey) + 1
+	board[failedPathsKey] = failed
+	if failed <= len(g.patrolPoints) {
+		g.chasing, g.Alert = "", alertReturn
+		g.currentPoint = (g.currentPoint + 1) % len(g.patrolPoints)
+		g.goal = g.patrolPoints[g.currentPoint]
+		g.route = nil // leads somewhere else
+		log.Println(g.Id, "can't find its way, trying patrol point", g.goal)
+		return behavior.Success
+	}
+	log.Println(g.Id, "is lost at", state{x: g.X, y: g.Y}, "and backs out")
+	if !backOut(th, float32(failed-len(g.patrolPoints))*stuckRadius) {
+		return behavior.Failure
+	}
+	return behavior.Running
+}
+
+// backOut walks th's guard to the nearest open cell at least away from it that it can get to in a
+// straight line, and from which it can get back to its patrol. Past escapeReach it starts again
+// from stuckRadius.
+func backOut(th *thought, away float32) bool {
+	g, m := th.g, th.m
+	at := state{x: g.X, y: g.Y}
+	region := int32(-1)
+	if len(g.patrolPoints) > 0 {
+		if r, ok := m.nav.regionAt(g.patrolPoints[0]); ok {
+			region = r
+		}
+	}
+	path, ok := m.nav.escape(m, g, away, region)
+	if !ok && away > stuckRadius {
+		path, ok = m.nav.escape(m, g, stuckRadius, region)
+	}
+	if !ok {
+		return false
+	}
+	g.route = path
+	th.actions = walkActions(at, path, m.guardStep(g))
+	return true
+}
+
+// escape returns the way out for g: straight to the nearest open cell at least away from it in
+// region, or in any region if region is negative. The guard may not be on the grid at all, and
+// other guards may be in the way, so the walk there is checked against m rather than the grid.
+func (n *navGrid) escape(m model, g *guard, away float32, region int32) ([]state, bool) {
+	at := state{x: g.X, y: g.Y}
+	near, ok := n.nearestCell(at)
+	if !ok {
+		return nil, false
+	}
+	col, row := near%n.cols, near/n.cols
+	var candidates []int
+	for r := max(0, row-escapeReach); r <= min(n.rows-1, row+escapeReach); r++ {
+		for c := max(0, col-escapeReach); c <= min(n.cols-1, col+escapeReach); c++ {
+			cell := r*n.cols + c
+			if n.open[cell] && (region < 0 || n.region[cell] == region) && n.center(cell).distanceTo(at) >= away {
+				candidates = append(candidates, cell)
+			}
+		}
+	}
+	slices.SortFunc(candidates, func(a, b int) int {
+		return cmp.Compare(n.center(a).distanceTo(at), n.center(b).distanceTo(at))
+	})
+	for _, cell := range candidates {
+		if to := n.center(cell); m.canWalk(g, to) {
+			return []state{to}, true
+		}
+	}
+	return nil, false
+}
+
+// nearestCell returns the cell whose center is nearest s, open or not, and false if s is so far
+// off the grid that no cell is near it
+func (n *navGrid) nearestCell(s state) (int, bool) {
+	col := min(max(0, int((s.x-n.originX)/navCellSize+0.5)), n.cols-1)
+	row := min(max(0, int((s.y-n.originY)/navCellSize+0.5)), n.rows-1)
+	cell := row*n.cols + col
+	return cell, n.center(cell).distanceTo(s) <= escapeReach*navCellSize
+}
+
+// canWalk reports whether g fits everywhere along the straight line from where it stands to b, clear
+// of obstacles and other guards. Where it stands is left out, so that a guard caught against
+// something can still walk away from it.
+func (m *model) canWalk(g *guard, b state) bool {
+	a := state{x: g.X, y: g.Y}
+	samples := int(a.distanceTo(b)/sightStep) + 1
+	for i := 1; i <= samples; i++ {
+		t := float32(i) / float32(samples)
+		s := state{x: a.x + (b.x-a.x)*t, y: a.y + (b.y-a.y)*t}
+		if !m.guardFits(s, g.kind.Radius) || !m.clearOfGuards(g, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkPatrols makes sure every guard that walks can get to each of its patrol points from where it
+// starts. Points it can't are left out of its patrol and reported, and a guard left with no patrol
+// at all keeps to where it starts.
+func checkPatrols(nav *navGrid, guards []guard) error {
+	var errs []error
+	for i := range guards {
+		g := &guards[i]
+		if g.posted() {
+			continue
+		}
+		start := state{x: g.X, y: g.Y}
+		region, ok := nav.regionAt(start)
+		if !ok {
+			errs = append(errs, fmt.Errorf("guard %s starts at (%v, %v), where it has no room to walk", g.Id, g.X, g.Y))
+			continue
+		}
+		g.patrolPoints = slices.DeleteFunc(g.patrolPoints, func(point state) bool {
+			if r, ok := nav.regionAt(point); ok && r == region {
+				return false
+			}
+			errs = append(errs, fmt.Errorf("guard %s can't reach its patrol point (%v, %v), leaving it out", g.Id, point.x, point.y))
+			return true
+		})
+		if len(g.patrolPoints) == 0 {
+			g.patrolPoints = append(g.patrolPoints, start)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// guard1's second patrol point is shut in a box, guard2 has no patrol at all, and guard3 walks
+// along under a wall
+const recoveryMap = `{
+	"obstacles": [
+		{"x": 550, "y": -650, "width": 100, "height": 10},
+		{"x": 550, "y": -560, "width": 100, "height": 10},
+		{"x": 550, "y": -650, "width": 10, "height": 100},
+		{"x": 640, "y": -650, "width": 10, "height": 100},
+		{"x": 1400, "y": -380, "width": 200, "height": 20}
+	],
+	"items": [],
+	"guards": [
+		{"id": "guard1", "x": 300, "y": -400, "rotation": 0, "patrolPoints": [{"x": 300, "y": -400}, {"x": 600, "y": -600}]},
+		{"id": "guard2", "x": 1000, "y": -400, "rotation": 0},
+		{"id": "guard3", "x": 1500, "y": -410, "rotation": 0, "patrolPoints": [{"x": 1500, "y": -410}, {"x": 1500, "y": -700}]}
+	]
+}`
+
+func TestUnreachablePatrolPointIsLeftOut(t *testing.T) {
+	sim := newSimulation(t, recoveryMap, 1)
+	if g := sim.guard("guard1"); !slices.Equal(g.patrolPoints, []state{{x: 300, y: -400}}) {
+		t.Errorf("guard1 patrols %v, want the point in the box left out", g.patrolPoints)
+	}
+
+	g := guard{Id: "guard1", kind: standardGuard(defaultConfig()), X: 300, Y: -400, patrolPoints: []state{{x: 600, y: -600}}}
+	err := checkPatrols(sim.hub.nav, []guard{g})
+	if err == nil || !strings.Contains(err.Error(), "(600, -600)") {
+		t.Errorf("checking the patrol reported %v, want the point in the box", err)
+	}
+}
+
+func TestGuardWithoutPatrolKeepsToItsPost(t *testing.T) {
+	sim := newSimulation(t, recoveryMap, 1)
+	g := sim.guard("guard2")
+	if !slices.Equal(g.patrolPoints, []state{{x: 1000, y: -400}}) {
+		t.Fatalf("guard2 patrols %v, want just where it starts", g.patrolPoints)
+	}
+
+	thief := sim.join("thief")
+	g.X, g.Y = 1200, -400
+	sim.hub.killPlayer(g, sim.player(thief))
+	sim.run(10 * sim.hub.cfg.TickRate)
+	if (state{x: g.X, y: g.Y}).distanceTo(state{x: 1000, y: -400}) >= goalReachedDistance || g.Alert != alertPatrol {
+		t.Errorf("guard2 is %v at (%v, %v), want it back on patrol where it started", g.Alert, g.X, g.Y)
+	}
+}
+
+func TestGuardWalkingIntoAWallGoesAroundWithoutTeleporting(t *testing.T) {
+	sim := newSimulation(t, recoveryMap, 1)
+	g := sim.guard("guard3")
+	// Walking straight at the wall, as if it had a route through it
+	g.goal = state{x: 1500, y: -200}
+	m := sim.hub.model()
+	step := m.guardStep(g)
+	for range 2 * sim.hub.ticksIn(sim.hub.cfg.StuckTime) {
+		g.actions = append(g.actions, action{deltaY: step})
+	}
+
+	arrived := false
+	for range 3 * sim.hub.ticksIn(sim.hub.cfg.StuckTime) {
+		from := state{x: g.X, y: g.Y}
+		sim.run(1)
+		at := state{x: g.X, y: g.Y}
+		if moved := from.distanceTo(at); moved > step*1.01 {
+			t.Fatalf("guard3 jumped %v from %v in a tick", moved, from)
+		}
+		if !m.guardFits(at, g.kind.Radius) {
+			t.Fatalf("guard3 walked into the wall at %v", at)
+		}
+		if g.stuck {
+			t.Fatalf("guard3 was left pressing on the wall until it was stuck at %v", at)
+		}
+		if at.distanceTo(state{x: 1500, y: -200}) < goalReachedDistance {
+			arrived = true
+			break
+		}
+	}
+	if !arrived {
+		t.Errorf("guard3 is at (%v, %v), never around the wall", g.X, g.Y)
+	}
+}
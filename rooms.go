@@ -0,0 +1,325 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const publicRoomID = "public"
+
+// Join codes skip characters that are easy to misread, like 0/O and 1/I
+const codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+const codeLength = 4
+
+const defaultMaxPlayers = 16
+const maxMaxPlayers = 64
+
+const roomReapInterval = 30 * time.Second
+
+var (
+	errBadRoomOptions = errors.New("bad room options")
+	errTooManyRooms   = errors.New("too many rooms are open, try again later")
+)
+
+// A room is one running Hub along with what the lobby needs to know about it.
+// Its id doubles as the join code players share.
+type room struct {
+	id           string
+	mapName      string
+	hub          *Hub
+	private      bool     // private rooms are left out of the lobby listing
+	passwordHash [32]byte // zero when the room has no password
+	maxPlayers   int
+	lastUsed     time.Time // last time the room had players or was looked up
+}
+
+type roomOptions struct {
+	Map        string `json:"map"`
+	Private    bool   `json:"private"`
+	Password   string `json:"password"`
+	MaxPlayers int    `json:"maxPlayers"`
+}
+
+type roomInfo struct {
+	Code       string `json:"code"`
+	Map        string `json:"map"`
+	Players    int    `json:"players"`
+	MaxPlayers int    `json:"maxPlayers"`
+	Private    bool   `json:"private"`
+	Locked     bool   `json:"locked"` // whether a password is needed to join
+}
+
+// The roomManager owns every running Hub. The public room always exists; others are created on request,
+// up to cfg.MaxRooms in all, and removed once they have been idle for the configured RoomIdleTimeout.
+type roomManager struct {
+	sync.Mutex
+	cfg      config
+	rooms    map[string]*room
+	starting map[string]bool // join codes handed out to rooms whose hubs are still being built
+}
+
+func newRoomManager(cfg config) (*roomManager, error) {
+	rm := &roomManager{
+		cfg:      cfg,
+		rooms:    make(map[string]*room),
+		starting: make(map[string]bool),
+	}
+	public, err := rm.startRoom(publicRoomID, roomOptions{Map: cfg.DefaultMap, MaxPlayers: maxMaxPlayers})
+	if err != nil {
+		return nil, err
+	}
+	rm.rooms[publicRoomID] = public
+	return rm, nil
+}
+
+func (rm *roomManager) startRoom(id string, options roomOptions) (*room, error) {
+	hub, err := newHub(rm.cfg, rm.cfg.Maps[options.Map])
+	if err != nil {
+		return nil, fmt.Errorf("room %s: %w", id, err)
+	}
+	hub.start()
+	log.Println("room", id, "started with map", options.Map)
+	r := &room{
+		id:         id,
+		mapName:    options.Map,
+		hub:        hub,
+		private:    options.Private,
+		maxPlayers: options.MaxPlayers,
+		lastUsed:   time.Now(),
+	}
+	if options.Password != "" {
+		r.passwordHash = sha256.Sum256([]byte(options.Password))
+	}
+	return r, nil
+}
+
+// create starts a new room under a fresh join code, filling in defaults for unset options.
+// Rejected options are reported as errBadRoomOptions, and a full server as errTooManyRooms.
+// The hub is built without holding the lock, so players can go on finding rooms meanwhile.
+func (rm *roomManager) create(options roomOptions) (*room, error) {
+	if options.Map == "" {
+		options.Map = rm.cfg.DefaultMap
+	}
+	if _, ok := rm.cfg.Maps[options.Map]; !ok {
+		return nil, fmt.Errorf("%w: unknown map", errBadRoomOptions)
+	}
+	if options.MaxPlayers == 0 {
+		options.MaxPlayers = defaultMaxPlayers
+	}
+	if options.MaxPlayers < 1 || options.MaxPlayers > maxMaxPlayers {
+		return nil, fmt.Errorf("%w: max players out of range", errBadRoomOptions)
+	}
+
+	rm.Lock()
+	if len(rm.rooms)+len(rm.starting) >= rm.cfg.MaxRooms {
+		rm.Unlock()
+		return nil, errTooManyRooms
+	}
+	code := newJoinCode()
+	for rm.rooms[code] != nil || rm.starting[code] {
+		code = newJoinCode()
+	}
+	rm.starting[code] = true
+	rm.Unlock()
+
+	r, err := rm.startRoom(code, options)
+	rm.Lock()
+	defer rm.Unlock()
+	delete(rm.starting, code)
+	if err != nil {
+		return nil, err
+	}
+	rm.rooms[code] = r
+	return r, nil
+}
+
+func newJoinCode() string {
+	code := make([]byte, codeLength)
+	for i := range code {
+		code[i] = codeAlphabet[rand.IntN(len(codeAlphabet))]
+	}
+	return string(code)
+}
+
+// find returns the room with the given join code, defaulting to the public room when code is empty.
+// Looking a room up counts as using it, so it will not be reaped while someone is joining.
+func (rm *roomManager) find(code string) (*room, bool) {
+	id := strings.ToUpper(strings.TrimSpace(code))
+	if id == "" || strings.EqualFold(id, publicRoomID) {
+		id = publicRoomID
+	}
+	rm.Lock()
+	defer rm.Unlock()
+	r, ok := rm.rooms[id]
+	if ok {
+		r.lastUsed = time.Now()
+	}
+	return r, ok
+}
+
+// hubs returns every running hub keyed by room id
+func (rm *roomManager) hubs() map[string]*Hub {
+	rm.Lock()
+	defer rm.Unlock()
+	hubs := make(map[string]*Hub, len(rm.rooms))
+	for id, r := range rm.rooms {
+		hubs[id] = r.hub
+	}
+	return hubs
+}
+
+// requestedRoom finds the room for the ?code= and ?password= parameters of r,
+// writing an error response and returning false if it cannot be joined.
+func (rm *roomManager) requestedRoom(w http.ResponseWriter, r *http.Request) (*room, bool) {
+	found, ok := rm.find(r.URL.Query().Get("code"))
+	if !ok {
+		http.Error(w, "room not found", http.StatusNotFound)
+		return nil, false
+	}
+	if !found.passwordMatches(r.URL.Query().Get("password")) {
+		http.Error(w, "wrong password", http.StatusForbidden)
+		return nil, false
+	}
+	if found.hub.playerCount() >= found.maxPlayers {
+		http.Error(w, "room is full", http.StatusConflict)
+		return nil, false
+	}
+	return found, true
+}
+
+func (r *room) passwordMatches(password string) bool {
+	if r.passwordHash == [32]byte{} {
+		return true
+	}
+	hash := sha256.Sum256([]byte(password))
+	return subtle.ConstantTimeCompare(hash[:], r.passwordHash[:]) == 1
+}
+
+func (r *room) info() roomInfo {
+	return roomInfo{
+		Code:       r.id,
+		Map:        r.mapName,
+		Players:    r.hub.playerCount(),
+		MaxPlayers: r.maxPlayers,
+		Private:    r.private,
+		Locked:     r.passwordHash != [32]byte{},
+	}
+}
+
+// all copies out the open rooms, so that their hubs can be asked about them without holding rm locked
+func (rm *roomManager) all() []*room {
+	rm.Lock()
+	defer rm.Unlock()
+	rooms := make([]*room, 0, len(rm.rooms))
+	for _, r := range rm.rooms {
+		rooms = append(rooms, r)
+	}
+	return rooms
+}
+
+// list describes every room that is not private
+func (rm *roomManager) list() []roomInfo {
+	rooms := make([]roomInfo, 0)
+	for _, r := range rm.all() {
+		if r.private {
+			continue
+		}
+		rooms = append(rooms, r.info())
+	}
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].Code < rooms[j].Code
+	})
+	return rooms
+}
+
+func (rm *roomManager) mapNames() []string {
+	names := make([]string, 0, len(rm.cfg.Maps))
+	for name := range rm.cfg.Maps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// reapIdleRooms periodically stops rooms that have been empty for longer than the configured RoomIdleTimeout
+func (rm *roomManager) reapIdleRooms() {
+	reapTicker := time.NewTicker(roomReapInterval)
+	for range reapTicker.C {
+		for _, r := range rm.all() {
+			if r.id == publicRoomID {
+				continue
+			}
+			players := r.hub.playerCount()
+			rm.Lock()
+			if players > 0 {
+				r.lastUsed = time.Now()
+			}
+			// Looking the room up since it was copied out also counts as use, so this rechecks it is idle
+			idle := rm.rooms[r.id] == r && time.Since(r.lastUsed) >= rm.cfg.RoomIdleTimeout.Duration
+			if idle {
+				delete(rm.rooms, r.id)
+			}
+			rm.Unlock()
+			if idle {
+				r.hub.stop()
+				log.Println("room", r.id, "closed after being idle")
+			}
+		}
+	}
+}
+
+// handleRooms lists public rooms on GET and creates one from a JSON roomOptions body on POST
+func handleRooms(rm *roomManager, w http.ResponseWriter, r *http.Request) {
+	var body any
+	switch r.Method {
+	case http.MethodGet:
+		body = struct {
+			Rooms []roomInfo `json:"rooms"`
+			Maps  []string   `json:"maps"`
+		}{
+			Rooms: rm.list(),
+			Maps:  rm.mapNames(),
+		}
+	case http.MethodPost:
+		var options roomOptions
+		if r.Body != nil && r.ContentLength != 0 {
+			err := json.NewDecoder(r.Body).Decode(&options)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		created, err := rm.create(options)
+		switch {
+		case errors.Is(err, errBadRoomOptions):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, errTooManyRooms):
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		case err != nil:
+			log.Println("unable to create room:", err)
+			http.Error(w, "room could not be started", http.StatusInternalServerError)
+			return
+		}
+		body = created.info()
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		log.Println("error writing rooms response:", err)
+	}
+}
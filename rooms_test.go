@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRoomsAreCapped(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.MaxRooms = 2
+	cfg.Maps["missing"] = "./no such map.json"
+	rm, err := newRoomManager(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for _, hub := range rm.hubs() {
+			hub.stop()
+		}
+	})
+
+	if _, err := rm.create(roomOptions{Map: "missing"}); err == nil || errors.Is(err, errBadRoomOptions) {
+		t.Errorf("creating a room on a map that can't be read gave %v, want the read error", err)
+	}
+	if _, err := rm.create(roomOptions{}); err != nil {
+		t.Fatalf("creating the second room: %v", err)
+	}
+	if _, err := rm.create(roomOptions{}); !errors.Is(err, errTooManyRooms) {
+		t.Errorf("creating a room past maxRooms gave %v, want %v", err, errTooManyRooms)
+	}
+	if rooms := len(rm.hubs()); rooms != 2 {
+		t.Errorf("%d rooms are open, want 2", rooms)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"log"
+)
+
+// How far a chased player can get from the end of a guard's route before the route is searched
+// for again from scratch, rather than extended from its end
+const repairRadius = 150
+
+// A leg is the walk from one of a guard's patrol points to the next
+type leg struct {
+	from state
+	to   state
+}
+
+// patrolRoutes holds the path of every patrol leg on the map, found once when the map loads.
+// Planners share it without locking, since nothing changes it afterwards.
+type patrolRoutes map[leg][]state
+
+func newPatrolRoutes(nav *navGrid, guards []guard) patrolRoutes {
+	routes := make(patrolRoutes)
+	for _, g := range guards {
+		for i, from := range g.patrolPoints {
+			to := g.patrolPoints[(i+1)%len(g.patrolPoints)]
+			if _, found := routes[leg{from, to}]; found || from == to {
+				continue
+			}
+			path, err := nav.findPath(context.Background(), from, to)
+			if err != nil {
+				log.Println(g.Id, "has no path from", from, "to", to, "on its patrol:", err)
+				continue
+			}
+			routes[leg{from, to}] = path
+		}
+	}
+	return routes
+}
+
+// walk returns the route for a guard at at setting off along l, if the leg has one and the guard
+// is still standing where it starts
+func (r patrolRoutes) walk(nav *navGrid, at state, l leg) ([]state, bool) {
+	route, found := r[l]
+	if !found || at.distanceTo(l.from) >= goalReachedDistance {
+		return nil, false
+	}
+	return nav.advance(at, route)
+}
+
+// advance drops the waypoints of route that a guard at at has already passed, so it heads straight
+// for the furthest one it can. It fails if the guard can't walk to the route at all.
+func (n *navGrid) advance(at state, route []state) ([]state, bool) {
+	for len(route) > 1 && n.clear(at, route[1]) {
+		route = route[1:]
+	}
+	if len(route) == 0 || !n.clear(at, route[0]) {
+		return nil, false
+	}
+	return route, true
+}
+
+// repair reroutes a guard at at, which was walking route, to end at to instead. It keeps as much of
+// the old route as it can and only searches for a new end, failing when the old route is no use.
+func (n *navGrid) repair(ctx context.Context, at state, route []state, to state) ([]state, bool) {
+	if !n.among(float64(to.x-n.originX)/navCellSize, float64(to.y-n.originY)/navCellSize) {
+		// Like findPath, stop at the nearest place a guard fits, such as beside a player against a wall
+		cell, ok := n.nearestOpen(to)
+		if !ok {
+			return nil, false
+		}
+		to = n.center(cell)
+	}
+	if n.clear(at, to) {
+		return []state{to}, true
+	}
+	route, ok := n.advance(at, route)
+	if !ok {
+		return nil, false
+	}
+	// Cut the route short at the first waypoint that sees the new end
+	for k := range route {
+		if n.clear(route[k], to) {
+			return append(route[:k+1:k+1], to), true
+		}
+	}
+	end := route[len(route)-1]
+	if end.distanceTo(to) > repairRadius {
+		return nil, false
+	}
+	tail, err := n.findPath(ctx, end, to)
+	if err != nil {
+		return nil, false
+	}
+	return append(route[:len(route):len(route)], tail...), true
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// wallModel has a long wall across the middle, which anything going from left to right walks around
+func wallModel() model {
+	m := model{obstacles: []obstacle{{X: -20, Y: -200, Width: 40, Height: 400}}}
+	m.nav = newNavGrid(m, []state{{x: -600, y: 0}, {x: 600, y: 0}}, guardRadius)
+	return m
+}
+
+func TestPatrolLegsAreReused(t *testing.T) {
+	h := realMapHub(t)
+	m := h.model()
+	for _, g := range h.guards {
+		for i, from := range g.patrolPoints {
+			to := g.patrolPoints[(i+1)%len(g.patrolPoints)]
+			cached, found := m.routes[leg{from, to}]
+			if !found || !m.nav.clear(from, cached[0]) {
+				continue // a guard standing here can't set off along the route anyway
+			}
+			g.X, g.Y = from.x, from.y
+			g.currentPoint = (i + 1) % len(g.patrolPoints)
+			g.goal = to
+			route, err := planRoute(context.Background(), &g, m)
+			if err != nil {
+				t.Errorf("%s from %v to %v: %v", g.Id, from, to, err)
+				continue
+			}
+			if len(route) == 0 || &route[len(route)-1] != &cached[len(cached)-1] {
+				t.Errorf("%s from %v to %v searched for a new path instead of walking its patrol route", g.Id, from, to)
+			}
+		}
+	}
+}
+
+func TestChaseRouteIsRepaired(t *testing.T) {
+	m := wallModel()
+	at, target := state{x: -300, y: 50}, state{x: 300, y: -50}
+	route, err := m.nav.findPath(context.Background(), at, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The guard set off and the player moved on a little, still behind the wall
+	at = state{x: at.x + (route[0].x-at.x)/2, y: at.y + (route[0].y-at.y)/2}
+	moved := state{x: 320, y: 0}
+	repaired, ok := m.nav.repair(context.Background(), at, route, moved)
+	if !ok {
+		t.Fatal("route was not repaired")
+	}
+	if repaired[0] != route[0] || repaired[len(repaired)-1] != moved {
+		t.Errorf("repaired route %v doesn't keep the start of %v and end at %v", repaired, route, moved)
+	}
+	from := at
+	for _, waypoint := range repaired {
+		if !m.nav.clear(from, waypoint) {
+			t.Errorf("repaired route cuts through the wall from %v to %v", from, waypoint)
+		}
+		from = waypoint
+	}
+}
+
+// chaseTargets is a player running along the far side of the real map's first guard
+func chaseTargets() []state {
+	var targets []state
+	for i := range 50 {
+		targets = append(targets, state{x: -200 + float32(i)*4, y: 340 - float32(i)*2})
+	}
+	return targets
+}
+
+// BenchmarkChaseReplan searches the whole way to every new position of a chased player
+func BenchmarkChaseReplan(b *testing.B) {
+	h := realMapHub(b)
+	m := h.model()
+	at := h.guards[0].patrolPoints[0]
+	b.ResetTimer()
+	for range b.N {
+		for _, target := range chaseTargets() {
+			if _, err := m.nav.findPath(context.Background(), at, target); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// BenchmarkChaseRepair follows the same player by repairing the end of the route each time
+func BenchmarkChaseRepair(b *testing.B) {
+	h := realMapHub(b)
+	m := h.model()
+	at := h.guards[0].patrolPoints[0]
+	b.ResetTimer()
+	for range b.N {
+		var route []state
+		for _, target := range chaseTargets() {
+			repaired, ok := m.nav.repair(context.Background(), at, route, target)
+			if !ok {
+				var err error
+				if repaired, err = m.nav.findPath(context.Background(), at, target); err != nil {
+					b.Fatal(err)
+				}
+			}
+			route = repaired
+		}
+	}
+}
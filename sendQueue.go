@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Close codes from the range websockets leave to applications, telling a client why it was disconnected
+const closeSlowConsumer = 4000
+
+var (
+	errQueueFull    = errors.New("send queue full")
+	errFallenBehind = errors.New("fell too far behind on snapshots")
+)
+
+type queuedKind int
+
+const (
+	queuedCritical queuedKind = iota // never dropped, like setScene and remove
+	queuedSnapshot                   // only the newest is worth sending
+	queuedScores                     // only the newest is worth sending
+)
+
+type queued struct {
+	message response
+	kind    queuedKind
+}
+
+// A sendQueue holds the messages waiting for one client's connection, so the hub can hand
+// them off without ever waiting on the network. Snapshots and scoreboards are coalesced:
+// a new one replaces any still waiting, which is safe because each one is complete against
+// the client's acknowledged baseline.
+type sendQueue struct {
+	sync.Mutex
+	pending     []queued
+	limit       int
+	slowAfter   time.Duration
+	behindSince time.Time     // when a snapshot was first replaced before being sent, zero while the client keeps up
+	ready       chan struct{} // signalled whenever pending grows or the queue closes
+	closed      bool
+}
+
+func newSendQueue(limit int, slowAfter time.Duration) *sendQueue {
+	return &sendQueue{
+		limit:     limit,
+		slowAfter: slowAfter,
+		ready:     make(chan struct{}, 1),
+	}
+}
+
+// push queues message without blocking. It fails without queueing when the client has more
+// messages waiting than the limit, or has had its snapshots replaced for longer than slowAfter.
+func (q *sendQueue) push(message response, kind queuedKind) error {
+	q.Lock()
+	defer q.Unlock()
+	if q.closed {
+		return nil
+	}
+	if kind != queuedCritical {
+		for i, waiting := range q.pending {
+			if waiting.kind != kind {
+				continue
+			}
+			// The replacement goes to the back so it still arrives after any critical message queued before it
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			if kind == queuedSnapshot {
+				if q.behindSince.IsZero() {
+					q.behindSince = time.Now()
+				} else if time.Since(q.behindSince) > q.slowAfter {
+					return errFallenBehind
+				}
+			}
+			break
+		}
+	}
+	if len(q.pending) >= q.limit {
+		return errQueueFull
+	}
+	q.pending = append(q.pending, queued{message: message, kind: kind})
+	q.signal()
+	return nil
+}
+
+// next waits for the oldest queued message, returning false once the queue is closed
+func (q *sendQueue) next() (response, bool) {
+	for {
+		q.Lock()
+		if q.closed {
+			q.Unlock()
+			return nil, false
+		}
+		if len(q.pending) > 0 {
+			oldest := q.pending[0]
+			q.pending[0] = queued{}
+			q.pending = q.pending[1:]
+			if oldest.kind == queuedSnapshot {
+				q.behindSince = time.Time{}
+			}
+			q.Unlock()
+			return oldest.message, true
+		}
+		q.Unlock()
+		<-q.ready
+	}
+}
+
+// close drops anything still waiting and wakes the writer so it can stop. Closing twice is harmless.
+func (q *sendQueue) close() {
+	q.Lock()
+	defer q.Unlock()
+	q.closed = true
+	q.pending = nil
+	q.signal()
+}
+
+// signal wakes next without blocking; the lock must be held
+func (q *sendQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSendQueueCoalescesSnapshots(t *testing.T) {
+	q := newSendQueue(8, time.Minute)
+	q.push(updateResponse{Tick: 1}, queuedSnapshot)
+	q.push(removeResponse{Type: "item", Id: "coin1"}, queuedCritical)
+	q.push(updateResponse{Tick: 2}, queuedSnapshot)
+	q.push(updateResponse{Tick: 3}, queuedSnapshot)
+
+	want := []response{removeResponse{Type: "item", Id: "coin1"}, updateResponse{Tick: 3}}
+	for _, expected := range want {
+		got, ok := q.next()
+		if !ok {
+			t.Fatal("queue closed early")
+		}
+		if !sameResponse(got, expected) {
+			t.Errorf("got %+v, want %+v", got, expected)
+		}
+	}
+}
+
+func TestSendQueueNeverDropsCriticalMessages(t *testing.T) {
+	q := newSendQueue(3, time.Minute)
+	for i := range 3 {
+		err := q.push(removeResponse{Type: "player", Id: fmt.Sprint("player", i)}, queuedCritical)
+		if err != nil {
+			t.Fatalf("push %d: %v", i, err)
+		}
+	}
+	err := q.push(removeResponse{Type: "player", Id: "player9"}, queuedCritical)
+	if !errors.Is(err, errQueueFull) {
+		t.Errorf("pushing past the limit gave %v, want %v", err, errQueueFull)
+	}
+}
+
+func TestSendQueueEvictsClientsThatFallBehind(t *testing.T) {
+	q := newSendQueue(8, 10*time.Millisecond)
+	q.push(updateResponse{Tick: 1}, queuedSnapshot)
+	q.push(updateResponse{Tick: 2}, queuedSnapshot)
+	time.Sleep(20 * time.Millisecond)
+	err := q.push(updateResponse{Tick: 3}, queuedSnapshot)
+	if !errors.Is(err, errFallenBehind) {
+		t.Errorf("got %v, want %v", err, errFallenBehind)
+	}
+
+	// Taking a snapshot means the client caught up
+	q = newSendQueue(8, 10*time.Millisecond)
+	q.push(updateResponse{Tick: 1}, queuedSnapshot)
+	q.push(updateResponse{Tick: 2}, queuedSnapshot)
+	time.Sleep(20 * time.Millisecond)
+	q.next()
+	err = q.push(updateResponse{Tick: 3}, queuedSnapshot)
+	if err != nil {
+		t.Errorf("client that caught up was evicted: %v", err)
+	}
+}
+
+func TestSendQueueCloseWakesWriter(t *testing.T) {
+	q := newSendQueue(8, time.Minute)
+	done := make(chan bool)
+	go func() {
+		_, ok := q.next()
+		done <- ok
+	}()
+	q.close()
+	if <-done {
+		t.Error("next returned a message from a closed queue")
+	}
+}
+
+func sameResponse(a, b response) bool {
+	encodedA, _ := a.JSONFormat()
+	encodedB, _ := b.JSONFormat()
+	return string(encodedA) == string(encodedB)
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"math"
+	"os"
+	"testing"
+)
+
+// Every map here stays clear of the restricted area around the spawn point at the origin
+
+// A guard north of the spawn, facing south towards where test players stand
+const watchtowerMap = `{
+	"obstacles": [],
+	"items": [],
+	"guards": [
+		{"id": "guard1", "x": 0, "y": -400, "rotation": 3.14159, "patrolPoints": [{"x": 0, "y": -400}, {"x": 0, "y": -600}]}
+	]
+}`
+
+// The same guard, but with a wall between it and the test players
+const walledMap = `{
+	"obstacles": [{"x": -100, "y": -330, "width": 200, "height": 20, "color": "#008", "stroke": "none"}],
+	"items": [],
+	"guards": [
+		{"id": "guard1", "x": 0, "y": -400, "rotation": 3.14159, "patrolPoints": [{"x": 0, "y": -400}, {"x": 0, "y": -600}]}
+	]
+}`
+
+const coinMap = `{
+	"obstacles": [],
+	"items": [{"id": "coin1", "type": "coin", "x": 300, "y": 0}],
+	"guards": []
+}`
+
+func TestGuardSpotsChasesAndCatchesPlayer(t *testing.T) {
+	sim := newSimulation(t, watchtowerMap, 1)
+	thief := sim.join("thief")
+	sim.place(thief, 0, -250)
+	sim.player(thief).Score = 3
+
+	sim.run(1)
+	g := sim.guard("guard1")
+	if g.Alert != alertSuspicious || g.suspect != sim.player(thief).Id {
+		t.Fatalf("guard is %v of %q, want it suspicious of the player in front of it", g.Alert, g.suspect)
+	}
+	sim.run(sim.hub.ticksIn(sim.hub.cfg.SuspicionTime))
+	if g.Alert != alertChase || g.chasing != sim.player(thief).Id {
+		t.Fatalf("guard is %v after %q, want it chasing the player it kept seeing", g.Alert, g.chasing)
+	}
+
+	for tick := 0; tick < 300 && sim.player(thief).Score != 0; tick++ {
+		sim.run(1)
+	}
+	p := sim.player(thief)
+	if p.Score != 0 || p.X != 0 || p.Y != 0 {
+		t.Fatalf("player was not caught: score %d at (%v, %v)", p.Score, p.X, p.Y)
+	}
+	if scenes := thief.scenesReceived(); scenes != 2 {
+		t.Errorf("player got %d scenes, want one for joining and one for respawning", scenes)
+	}
+	if g.chasing != "" || g.Alert != alertReturn {
+		t.Errorf("guard is %v after %q following the catch, want it returning to its patrol", g.Alert, g.chasing)
+	}
+}
+
+func TestGuardInvestigatesThenReturnsToPatrol(t *testing.T) {
+	sim := newSimulation(t, watchtowerMap, 1)
+	thief := sim.join("thief")
+	sim.place(thief, 0, -250)
+	sim.run(sim.hub.ticksIn(sim.hub.cfg.SuspicionTime) + 1)
+	g := sim.guard("guard1")
+	if g.Alert != alertChase {
+		t.Fatalf("guard is %v, want it chasing", g.Alert)
+	}
+
+	sim.place(sim.clients[0], 1000, 1000) // gone, as far as the guard can tell
+	lost := g.lastKnown
+	for tick := 0; tick < 300 && g.Alert == alertChase; tick++ {
+		sim.run(1)
+	}
+	if g.Alert != alertInvestigate || g.chasing != "" {
+		t.Fatalf("guard that lost its target is %v after %q, want it investigating", g.Alert, g.chasing)
+	}
+	if (state{x: g.X, y: g.Y}).distanceTo(lost) >= goalReachedDistance {
+		t.Errorf("guard started investigating at (%v, %v), away from where it lost the player at %v", g.X, g.Y, lost)
+	}
+
+	sim.run(sim.hub.ticksIn(sim.hub.cfg.InvestigateTime))
+	if g.Alert != alertReturn {
+		t.Fatalf("guard is %v after its investigation, want it returning to its patrol", g.Alert)
+	}
+	if g.sweep == 0 {
+		t.Error("guard never searched around where it lost the player")
+	}
+	for tick := 0; tick < 1200 && g.Alert == alertReturn; tick++ {
+		sim.run(1)
+	}
+	if g.Alert != alertPatrol {
+		t.Errorf("guard is %v, want it back on patrol", g.Alert)
+	}
+}
+
+func TestGuardLosingPlayerInSpawnAreaInvestigates(t *testing.T) {
+	sim := newSimulation(t, watchtowerMap, 1)
+	thief := sim.join("thief")
+	sim.place(thief, 0, -250)
+	sim.run(sim.hub.ticksIn(sim.hub.cfg.SuspicionTime) + 1)
+	g := sim.guard("guard1")
+	if g.Alert != alertChase {
+		t.Fatalf("guard is %v, want it chasing", g.Alert)
+	}
+
+	sim.place(thief, 0, -60) // where guards can't follow
+	sim.run(10 * sim.hub.cfg.TickRate)
+	sim.place(thief, 1000, 1000)
+	for tick := 0; tick < 600 && g.Alert == alertChase; tick++ {
+		sim.run(1)
+	}
+	if g.Alert != alertInvestigate {
+		t.Errorf("guard is %v at (%v, %v), want it investigating short of the spawn area", g.Alert, g.X, g.Y)
+	}
+}
+
+func TestGuardCannotSeeThroughWalls(t *testing.T) {
+	sim := newSimulation(t, walledMap, 1)
+	thief := sim.join("thief")
+	sim.place(thief, 0, -250)
+
+	sim.run(30)
+	if g := sim.guard("guard1"); g.Alert != alertPatrol {
+		t.Errorf("guard is %v of a player behind a wall", g.Alert)
+	}
+}
+
+func TestGuardForgetsPlayerWhoLeaves(t *testing.T) {
+	sim := newSimulation(t, watchtowerMap, 1)
+	thief := sim.join("thief")
+	sim.place(thief, 0, -250)
+	sim.run(1)
+	sim.leave(thief)
+
+	sim.run(30)
+	g := sim.guard("guard1")
+	if g.chasing != "" || g.suspect != "" || g.Alert != alertInvestigate {
+		t.Errorf("guard is %v after %q once its target left, want it investigating", g.Alert, g.suspect+g.chasing)
+	}
+}
+
+// The guard starts facing south and patrols north, so it has to turn around
+func TestGuardTurnsGradually(t *testing.T) {
+	sim := newSimulation(t, watchtowerMap, 1)
+	g := sim.guard("guard1")
+	m := sim.hub.model()
+	turn := m.guardTurn(g)
+	before := g.Rotation
+	for range 120 {
+		sim.run(1)
+		if turned := math.Abs(math.Remainder(float64(g.Rotation-before), 2*math.Pi)); turned > float64(turn)*1.001 {
+			t.Fatalf("guard turned %v in one tick, more than %v", turned, turn)
+		}
+		before = g.Rotation
+	}
+	if math.Abs(float64(g.Rotation)) > 0.01 {
+		t.Errorf("guard walking north faces %v, want 0", g.Rotation)
+	}
+}
+
+// Behind the watchtower guard, out of sight but within earshot of a sprint
+func TestGuardHearsSprintingPlayer(t *testing.T) {
+	sim := newSimulation(t, watchtowerMap, 1)
+	runner := sim.join("runner")
+	sim.place(runner, 150, -550)
+	runner.sprint(1, 0, 10)
+
+	sim.run(10)
+	g := sim.guard("guard1")
+	if g.Alert != alertInvestigate {
+		t.Fatalf("guard is %v, want it investigating the footsteps behind it", g.Alert)
+	}
+	if p := sim.player(runner); g.lastKnown.distanceTo(state{x: p.X, y: p.Y}) > searchRadius {
+		t.Errorf("guard is investigating %v, nowhere near the player at (%v, %v)", g.lastKnown, p.X, p.Y)
+	}
+}
+
+func TestGuardDoesNotHearSneakingPlayer(t *testing.T) {
+	sim := newSimulation(t, watchtowerMap, 1)
+	sneaker := sim.join("sneaker")
+	sim.place(sneaker, 150, -550)
+	sneaker.sneak(1, 0, 10)
+
+	sim.run(10)
+	if g := sim.guard("guard1"); g.Alert != alertPatrol {
+		t.Errorf("guard is %v, want it still patrolling while the player sneaks by", g.Alert)
+	}
+	if x := sim.player(sneaker).X; x != 150+10*sim.hub.model().sneakStep {
+		t.Errorf("sneaking player is at x %v after 10 ticks, want %v", x, 150+10*sim.hub.model().sneakStep)
+	}
+}
+
+func TestPlayerPicksUpCoin(t *testing.T) {
+	sim := newSimulation(t, coinMap, 1)
+	collector := sim.join("collector")
+	sim.place(collector, 240, 0)
+	collector.walk(1, 0, 20)
+	collector.grab("coin1")
+
+	sim.run(20)
+	if score := sim.player(collector).Score; score != 1 {
+		t.Errorf("score is %d after picking up a coin, want 1", score)
+	}
+	if len(sim.hub.items) != 0 {
+		t.Errorf("coin is still in the world: %+v", sim.hub.items)
+	}
+	removed := false
+	for _, message := range collector.received {
+		if message == (removeResponse{Type: "item", Id: "coin1"}) {
+			removed = true
+		}
+	}
+	if !removed {
+		t.Error("player was not told the coin is gone")
+	}
+}
+
+func TestPickupOutOfReachIsRejected(t *testing.T) {
+	sim := newSimulation(t, coinMap, 1)
+	cheater := sim.join("cheater")
+	sim.place(cheater, -300, 0)
+	cheater.walk(1, 0, 5)
+	cheater.grab("coin1")
+
+	sim.run(5)
+	p := sim.player(cheater)
+	if p.Score != 0 || len(sim.hub.items) != 1 {
+		t.Errorf("coin out of reach was picked up, score %d", p.Score)
+	}
+	if len(p.flagged) != 1 {
+		t.Errorf("got %d violations for grabbing a coin out of reach, want 1", len(p.flagged))
+	}
+}
+
+// Movement only checks where a step ends, so a diagonal step past a corner can clip it by a sliver
+// on the way. The trajectory check must not count that against the player.
+func TestPickupAfterCuttingACornerIsAccepted(t *testing.T) {
+	sim := newSimulation(t, `{
+		"obstacles": [{"x": 0, "y": 0, "width": 100, "height": 100}],
+		"items": [{"id": "coin1", "type": "coin", "x": -16, "y": -40}],
+		"guards": []
+	}`, 1)
+	runner := sim.join("runner")
+	sim.place(runner, -18.835, -16.478)
+	runner.wait(1)
+	runner.sprint(0.70710677, -0.70710677, 1)
+	runner.grab("coin1")
+
+	sim.run(2)
+	p := sim.player(runner)
+	if p.Score != 1 || len(p.flagged) != 0 {
+		t.Errorf("pickup right after passing a corner scored %d with violations %v", p.Score, p.flagged)
+	}
+}
+
+// A network stall hands the server a burst of inputs at once, which an honest client can't help
+func TestInputBurstIsNoViolation(t *testing.T) {
+	sim := newSimulation(t, coinMap, 1)
+	p := sim.player(sim.join("laggy"))
+	for seq := 1; seq <= 3*maxPendingInputs; seq++ {
+		if err := p.queueInput(input{seq: seq, dirX: 1}); err != nil {
+			t.Fatalf("input %d in a burst: %v", seq, err)
+		}
+	}
+	if len(p.inputs) != maxPendingInputs || p.inputs[0].seq != 2*maxPendingInputs+1 {
+		t.Errorf("kept %d inputs from %d, want the newest %d", len(p.inputs), p.inputs[0].seq, maxPendingInputs)
+	}
+}
+
+func TestItemTouchedInADroppedInputIsStillPickedUp(t *testing.T) {
+	sim := newSimulation(t, coinMap, 1)
+	laggy := sim.join("laggy")
+	sim.place(laggy, 280, 0)
+	sim.run(1)
+	p := sim.player(laggy)
+	seq := p.LastInput
+	for i := range 3 * maxPendingInputs {
+		seq++
+		in := input{seq: seq}
+		if i == 0 {
+			in.interaction = "coin1"
+		}
+		p.queueInput(in)
+	}
+	sim.run(maxPendingInputs)
+	if p.Score != 1 || len(p.flagged) != 0 {
+		t.Errorf("grab at the start of a burst scored %d with violations %v", p.Score, p.flagged)
+	}
+}
+
+// Players who see the same things and acknowledged the same snapshot get one serialized message
+func TestSnapshotsAreSharedBetweenPlayersSeeingTheSame(t *testing.T) {
+	sim := newSimulation(t, coinMap, 1)
+	first, second, loner := sim.join("first"), sim.join("second"), sim.join("loner")
+	sim.place(first, 250, 0)
+	sim.place(second, 350, 0)
+	sim.place(loner, 5000, 5000)
+	sim.run(3)
+
+	latest := func(fc *fakeClient) []byte {
+		for i := len(fc.received) - 1; i >= 0; i-- {
+			if message, ok := fc.received[i].(encodedResponse); ok {
+				return message.json
+			}
+		}
+		t.Fatal("no snapshot received")
+		return nil
+	}
+	if &latest(first)[0] != &latest(second)[0] {
+		t.Error("players side by side were each sent their own copy of the same snapshot")
+	}
+	if &latest(first)[0] == &latest(loner)[0] {
+		t.Error("a player far away was sent the snapshot of players it can't see")
+	}
+}
+
+// Two runs of the real map with the same seed and scripts must end in exactly the same world
+func TestSimulationIsDeterministic(t *testing.T) {
+	if testing.Short() {
+		t.Skip("plans paths on the full map")
+	}
+	mapData, err := os.ReadFile("./mapData.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	play := func() *simulation {
+		sim := newSimulation(t, string(mapData), 42)
+		runner := sim.join("runner")
+		runner.walk(1, 0, 100)
+		runner.walk(0, 1, 200)
+		runner.walk(-1, 0, 150)
+		lurker := sim.join("lurker")
+		lurker.walk(0, -1, 100)
+		lurker.walk(-0.70710678, -0.70710678, 200)
+		sim.run(600)
+		return sim
+	}
+	first, second := play(), play()
+
+	moved := false
+	for i := range first.hub.guards {
+		a, b := first.hub.guards[i], second.hub.guards[i]
+		if a.X != b.X || a.Y != b.Y || a.Rotation != b.Rotation || a.chasing != b.chasing || a.goal != b.goal {
+			t.Errorf("%s ended at (%v, %v) chasing %q one run and (%v, %v) chasing %q the other",
+				a.Id, a.X, a.Y, a.chasing, b.X, b.Y, b.chasing)
+		}
+		if a.X != a.patrolPoints[0].x || a.Y != a.patrolPoints[0].y {
+			moved = true
+		}
+	}
+	if !moved {
+		t.Error("no guard moved, the simulation didn't run")
+	}
+	for i := range first.clients {
+		a, b := first.player(first.clients[i]), second.player(second.clients[i])
+		if a.X != b.X || a.Y != b.Y || a.Score != b.Score {
+			t.Errorf("%s ended at (%v, %v) with %d one run and (%v, %v) with %d the other",
+				a.Username, a.X, a.Y, a.Score, b.X, b.Y, b.Score)
+		}
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// How many past views of the world each client keeps to diff against, one per tick. A client whose last
+// acknowledged snapshot is older than this gets a full snapshot instead.
+const snapshotHistory = 32
+
+// A snapshot is the state of every player, guard and item at one tick, or, once filtered,
+// just the ones a single client can see. Entities are stored by value so a snapshot never
+// changes after it is taken.
+type snapshot struct {
+	num     int
+	players map[string]playerState
+	guards  map[string]guardState
+	items   map[string]item
+}
+
+type entityKind int
+
+const (
+	playerEntity entityKind = iota
+	guardEntity
+	itemEntity
+)
+
+type entityRef struct {
+	kind entityKind
+	id   string
+}
+
+type playerState struct {
+	Id        string
+	Username  string
+	X         float32
+	Y         float32
+	Rotation  float32
+	Score     int
+	LastInput int
+}
+
+type guardState struct {
+	Id       string
+	Type     string
+	X        float32
+	Y        float32
+	Rotation float32
+	Alert    alert
+	Offline  bool
+}
+
+// A playerDelta holds only the fields of a player that changed since the baseline; nil fields are unchanged.
+// A player that is new since the baseline has every field set.
+type playerDelta struct {
+	Id        string   `json:"id"`
+	Username  *string  `json:"username,omitempty"`
+	X         *float32 `json:"x,omitempty"`
+	Y         *float32 `json:"y,omitempty"`
+	Rotation  *float32 `json:"rotation,omitempty"`
+	Score     *int     `json:"score,omitempty"`
+	LastInput *int     `json:"lastInput,omitempty"`
+}
+
+type guardDelta struct {
+	Id       string   `json:"id"`
+	Type     *string  `json:"type,omitempty"`
+	X        *float32 `json:"x,omitempty"`
+	Y        *float32 `json:"y,omitempty"`
+	Rotation *float32 `json:"rotation,omitempty"`
+	Alert    *alert   `json:"alert,omitempty"`
+	Offline  *bool    `json:"offline,omitempty"` // switched off at a terminal
+}
+
+// takeSnapshot captures the world as of the current tick and indexes it by position
+func (h *Hub) takeSnapshot() *snapshot {
+	current := &snapshot{
+		num:     h.tick,
+		players: make(map[string]playerState, len(h.players)),
+		guards:  make(map[string]guardState, len(h.guards)),
+		items:   make(map[string]item, len(h.items)),
+	}
+	h.entityGrid.clear()
+	for _, p := range h.players {
+		current.players[p.Id] = playerState{
+			Id:        p.Id,
+			Username:  p.Username,
+			X:         p.X,
+			Y:         p.Y,
+			Rotation:  p.Rotation,
+			Score:     p.Score,
+			LastInput: p.LastInput,
+		}
+		h.entityGrid.insert(p.X, p.Y, entityRef{kind: playerEntity, id: p.Id})
+	}
+	for _, g := range h.guards {
+		current.guards[g.Id] = guardState{
+			Id:       g.Id,
+			Type:     g.kind.Name,
+			X:        g.X,
+			Y:        g.Y,
+			Rotation: g.Rotation,
+			Alert:    g.Alert,
+			Offline:  h.offline(&g),
+		}
+		h.entityGrid.insert(g.X, g.Y, entityRef{kind: guardEntity, id: g.Id})
+	}
+	for _, it := range h.items {
+		current.items[it.Id] = it
+		h.entityGrid.insert(it.X, it.Y, entityRef{kind: itemEntity, id: it.Id})
+	}
+	return current
+}
+
+// updateInterest replaces p's interest set with every entity within the view radius of p.
+// Players are always interested in themselves. Callers must have just taken a snapshot.
+func (h *Hub) updateInterest(p *player) {
+	if p.interest == nil {
+		p.interest = make(map[entityRef]bool)
+	}
+	clear(p.interest)
+	p.interest[entityRef{kind: playerEntity, id: p.Id}] = true
+	h.entityGrid.query(p.X, p.Y, h.cfg.ViewRadius, func(ref entityRef) {
+		p.interest[ref] = true
+	})
+}
+
+// filter returns the part of the world in interest
+func (world *snapshot) filter(interest map[entityRef]bool) *snapshot {
+	view := &snapshot{
+		num:     world.num,
+		players: make(map[string]playerState),
+		guards:  make(map[string]guardState),
+		items:   make(map[string]item),
+	}
+	for ref := range interest {
+		switch ref.kind {
+		case playerEntity:
+			if p, ok := world.players[ref.id]; ok {
+				view.players[ref.id] = p
+			}
+		case guardEntity:
+			if g, ok := world.guards[ref.id]; ok {
+				view.guards[ref.id] = g
+			}
+		case itemEntity:
+			if it, ok := world.items[ref.id]; ok {
+				view.items[ref.id] = it
+			}
+		}
+	}
+	return view
+}
+
+// keepView remembers what p was sent in view so later snapshots can be diffed against it
+func (p *player) keepView(view *snapshot) {
+	if len(p.views) >= snapshotHistory {
+		p.views = p.views[1:]
+	}
+	p.views = append(p.views, view)
+}
+
+// baseline returns p's view numbered num, or nil if it is too old or was never sent
+func (p *player) baseline(num int) *snapshot {
+	for _, s := range p.views {
+		if s.num == num {
+			return s
+		}
+	}
+	return nil
+}
+
+// A sharedDelta is the delta from one view to another. Clients that see the same entities share
+// their views, so those that have acknowledged the same one share the message too.
+type sharedDelta struct {
+	base *snapshot
+	view *snapshot
+}
+
+// broadcastSnapshot sends each client the part of the newest snapshot within its view radius,
+// as a delta against the last view that client acknowledged. Entities show up in the delta in
+// full when they enter a client's view and among the removed ids when they leave it.
+// Clients with the same interest, baseline and encoding share one serialized message.
+func (h *Hub) broadcastSnapshot() {
+	world := h.takeSnapshot()
+	views := make(map[string]*snapshot)
+	encoded := make(map[sharedDelta]*encodedResponse)
+	for client, p := range h.players {
+		h.updateInterest(p)
+		key := interestKey(p.interest)
+		view, ok := views[key]
+		if !ok {
+			view = world.filter(p.interest)
+			views[key] = view
+		}
+		shared := sharedDelta{base: p.baseline(p.acked), view: view}
+		p.keepView(view)
+
+		message, ok := encoded[shared]
+		if !ok {
+			message = &encodedResponse{}
+			encoded[shared] = message
+		}
+		var err error
+		if client.binary && message.binary == nil {
+			message.binary, err = diffSnapshots(shared.base, view).BinaryFormat()
+		} else if !client.binary && message.json == nil {
+			message.json, err = diffSnapshots(shared.base, view).JSONFormat()
+		}
+		if err != nil {
+			log.Println("error marshaling snapshot: ", err)
+			continue
+		}
+		client.send(*message, queuedSnapshot)
+	}
+	if h.scoresChanged {
+		h.scoresChanged = false
+		scores := h.scoreboard()
+		for client := range h.players {
+			client.send(scores, queuedScores)
+		}
+	}
+}
+
+// interestKey names the set of entities in interest, the same for every client interested in them
+func interestKey(interest map[entityRef]bool) string {
+	refs := make([]string, 0, len(interest))
+	for ref := range interest {
+		refs = append(refs, strconv.Itoa(int(ref.kind))+ref.id)
+	}
+	slices.Sort(refs)
+	return strings.Join(refs, "\x00")
+}
+
+// scoreboard lists every player's score, since players out of view are not in snapshots
+func (h *Hub) scoreboard() scoreboardResponse {
+	scores := scoreboardResponse{Scores: make([]score, 0, len(h.players))}
+	for _, p := range h.players {
+		scores.Scores = append(scores.Scores, score{Username: p.Username, Score: p.Score})
+	}
+	return scores
+}
+
+// diffSnapshots describes how to get from base to current. A nil base describes all of current.
+func diffSnapshots(base *snapshot, current *snapshot) updateResponse {
+	if base == nil {
+		base = &snapshot{}
+	}
+	delta := updateResponse{
+		Tick:     current.num,
+		Baseline: base.num,
+		Players:  make([]playerDelta, 0),
+		Guards:   make([]guardDelta, 0),
+		Items:    make([]item, 0),
+	}
+	for id, now := range current.players {
+		before, existed := base.players[id]
+		if changed, ok := diffPlayer(before, now, existed); ok {
+			delta.Players = append(delta.Players, changed)
+		}
+	}
+	for id := range base.players {
+		if _, ok := current.players[id]; !ok {
+			delta.RemovedPlayers = append(delta.RemovedPlayers, id)
+		}
+	}
+	for id, now := range current.guards {
+		before, existed := base.guards[id]
+		if changed, ok := diffGuard(before, now, existed); ok {
+			delta.Guards = append(delta.Guards, changed)
+		}
+	}
+	for id := range base.guards {
+		if _, ok := current.guards[id]; !ok {
+			delta.RemovedGuards = append(delta.RemovedGuards, id)
+		}
+	}
+	for id, now := range current.items {
+		if before, existed := base.items[id]; !existed || before != now {
+			delta.Items = append(delta.Items, now)
+		}
+	}
+	for id := range base.items {
+		if _, ok := current.items[id]; !ok {
+			delta.RemovedItems = append(delta.RemovedItems, id)
+		}
+	}
+	return delta
+}
+
+// diffPlayer returns the fields of now that differ from before, and false if nothing did
+func diffPlayer(before playerState, now playerState, existed bool) (playerDelta, bool) {
+	delta := playerDelta{Id: now.Id}
+	changed := !existed
+	if !existed || before.Username != now.Username {
+		delta.Username, changed = &now.Username, true
+	}
+	if !existed || before.X != now.X {
+		delta.X, changed = &now.X, true
+	}
+	if !existed || before.Y != now.Y {
+		delta.Y, changed = &now.Y, true
+	}
+	if !existed || before.Rotation != now.Rotation {
+		delta.Rotation, changed = &now.Rotation, true
+	}
+	if !existed || before.Score != now.Score {
+		delta.Score, changed = &now.Score, true
+	}
+	if !existed || before.LastInput != now.LastInput {
+		delta.LastInput, changed = &now.LastInput, true
+	}
+	return delta, changed
+}
+
+func diffGuard(before guardState, now guardState, existed bool) (guardDelta, bool) {
+	delta := guardDelta{Id: now.Id}
+	changed := !existed
+	if !existed || before.X != now.X {
+		delta.X, changed = &now.X, true
+	}
+	if !existed || before.Y != now.Y {
+		delta.Y, changed = &now.Y, true
+	}
+	if !existed || before.Rotation != now.Rotation {
+		delta.Rotation, changed = &now.Rotation, true
+	}
+	if !existed || before.Alert != now.Alert {
+		delta.Alert, changed = &now.Alert, true
+	}
+	if !existed || before.Type != now.Type {
+		delta.Type, changed = &now.Type, true
+	}
+	if !existed || before.Offline != now.Offline {
+		delta.Offline, changed = &now.Offline, true
+	}
+	return delta, changed
+}
@@ -0,0 +1,62 @@
+package main
+
+import "math"
+
+type gridCell struct {
+	x int
+	y int
+}
+
+type gridEntry[T any] struct {
+	at    state
+	value T
+}
+
+// A spatialGrid buckets values by position so that finding everything near a point
+// only looks at the cells around it instead of the whole world.
+type spatialGrid[T any] struct {
+	cellSize float32
+	cells    map[gridCell][]gridEntry[T]
+}
+
+func newSpatialGrid[T any](cellSize float32) *spatialGrid[T] {
+	return &spatialGrid[T]{
+		cellSize: cellSize,
+		cells:    make(map[gridCell][]gridEntry[T]),
+	}
+}
+
+func (g *spatialGrid[T]) cellOf(x, y float32) gridCell {
+	return gridCell{
+		x: int(math.Floor(float64(x / g.cellSize))),
+		y: int(math.Floor(float64(y / g.cellSize))),
+	}
+}
+
+func (g *spatialGrid[T]) insert(x, y float32, value T) {
+	cell := g.cellOf(x, y)
+	g.cells[cell] = append(g.cells[cell], gridEntry[T]{at: state{x: x, y: y}, value: value})
+}
+
+// clear empties the grid but keeps its cells allocated for reuse
+func (g *spatialGrid[T]) clear() {
+	for cell, entries := range g.cells {
+		g.cells[cell] = entries[:0]
+	}
+}
+
+// query calls found for every value within radius of (x, y)
+func (g *spatialGrid[T]) query(x, y, radius float32, found func(T)) {
+	center := state{x: x, y: y}
+	low := g.cellOf(x-radius, y-radius)
+	high := g.cellOf(x+radius, y+radius)
+	for cellX := low.x; cellX <= high.x; cellX++ {
+		for cellY := low.y; cellY <= high.y; cellY++ {
+			for _, entry := range g.cells[gridCell{x: cellX, y: cellY}] {
+				if entry.at.distanceTo(center) <= radius {
+					found(entry.value)
+				}
+			}
+		}
+	}
+}
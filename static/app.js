@@ -10,7 +10,19 @@ let clientY = .5 * two.height;
 const game = {
     clientId: "",
     mouse: {x: 0, y: 0},
-    moveSpeed: 2,
+    moveSpeed: 130, // units per second, as on the server
+    sprintSpeed: 200,
+    sneakSpeed: 60,
+    tickRate: 60, // replaced by the server's when we join
+    guardTypes: {}, // by name, from the server when we join
+    inputsDue: 0,
+    lastInputTime: 0,
+    inputSeq: 0,
+    pendingInputs: [],
+    obstacleData: [],
+    snapshots: new Map(),
+    ack: 0,
+    world: {players: {}, guards: {}, items: {}},
     clientGlobalPos: {x: 0, y: 0},
     gridSize: 20,
     grid: null,
@@ -24,10 +36,12 @@ const game = {
 }
 
 const handleMessage = (event) => {
-    const {requesting} = JSON.parse(event.data);
-    switch (requesting) {
+    const message = typeof event.data === "string" ? JSON.parse(event.data) : protocol.decode(event.data);
+    switch (message.requesting) {
         case "setScene":
-            const {player, obstacles, items} = JSON.parse(event.data);
+            const {player, obstacles, items, tickRate, guardTypes} = message;
+            if (tickRate) game.tickRate = tickRate;
+            for (const guardType of guardTypes || []) game.guardTypes[guardType.name] = guardType;
             if (player.id) {
                 game.clientId = player.id;
                 game.grid.position.add(game.clientGlobalPos.x - player.x, game.clientGlobalPos.y -player.y);
@@ -36,34 +50,88 @@ const handleMessage = (event) => {
                 game.items.position.set(player.x, player.y);
                 game.players.position.set(player.x, player.y);
                 game.guards.position.set(player.x, player.y);
+                game.pendingInputs = [];
+            }
+            if (obstacles) {
+                game.obstacleData = obstacles.map((obstacle) => ({...obstacle}));
             }
             drawMap(obstacles, items);
             break;
         case "update":
-            const {players, guards} = JSON.parse(event.data);
+            const world = applySnapshot(message);
+            if (!world) break;
+            removeLeftView(game.world, world);
+            game.world = world;
+            // Copy the entities, drawing converts them to local coordinates in place
+            const players = Object.values(world.players).map((player) => ({...player}));
+            const guards = Object.values(world.guards).map((guard) => ({...guard}));
+            const self = players.find((player) => player.id === game.clientId);
+            if (self) reconcile(self);
             globalToLocalCoords(players, game.players);
             globalToLocalCoords(guards, game.guards);  
             updatePlayers(players);
             updateGuards(guards);
-            updateScoreboard(players);
+            drawMap(null, Object.values(world.items).map((item) => ({...item})));
+            break;
+        case "scoreboard":
+            updateScoreboard(message.scores);
             break;
         case "remove":
-            const {type, id: removeId} = JSON.parse(event.data);
+            const {type, id: removeId} = message;
             switch (type) {
                 case "player":
-                    game.players.children.ids[removeId].remove();
+                    game.players.children.ids[removeId]?.remove();
                     break;
                 case "item":
-                    game.items.children.ids[removeId].remove();
+                    game.items.children.ids[removeId]?.remove();
                     break;
             }
             break;
     }
 };
 
+const emptyWorld = () => ({players: {}, guards: {}, items: {}});
+
+// Takes down the drawings of everything that was in the last world we showed but has since left our view
+const removeLeftView = (before, after) => {
+    const drawn = {players: game.players, guards: game.guards, items: game.items};
+    for (const kind in drawn) {
+        for (const id in before[kind]) {
+            if (!(id in after[kind])) drawn[kind].children.ids[id]?.remove();
+        }
+    }
+};
+
+// Rebuilds the world from a delta against a snapshot we acknowledged earlier, returning null if we can't
+const applySnapshot = ({tick, baseline, players, guards, items, removedPlayers, removedGuards, removedItems}) => {
+    const base = baseline ? game.snapshots.get(baseline) : emptyWorld();
+    if (!base) return null;
+    const world = {players: {...base.players}, guards: {...base.guards}, items: {...base.items}};
+    for (const player of players) {
+        world.players[player.id] = {...world.players[player.id], ...player};
+    }
+    for (const guard of guards) {
+        world.guards[guard.id] = {...world.guards[guard.id], ...guard};
+    }
+    for (const id of removedPlayers || []) delete world.players[id];
+    for (const item of items || []) {
+        world.items[item.id] = item;
+    }
+    for (const id of removedGuards || []) delete world.guards[id];
+    for (const id of removedItems || []) delete world.items[id];
+
+    game.snapshots.set(tick, world);
+    for (const num of game.snapshots.keys()) {
+        if (num <= tick - 64) game.snapshots.delete(num); // the server never diffs against anything this old
+    }
+    game.ack = tick;
+    return world;
+};
+
 const startGame = () => {
     console.log("Connected to server");
-    setInterval(update, 15);
+    game.lastInputTime = performance.now();
+    setInterval(sendInputs, 5);
     document.getElementById("main-menu").remove();
     two.appendTo(document.body);
     two.play();
@@ -74,14 +142,36 @@ const connectionRefused = (event) => {
     game.socket = null;
 }
 
-const attemptConnection = (username) => {
-    fetch("/namecheck?username=" + encodeURIComponent(username), {method: "POST"})
+// What the server means by the codes it closes our connection with
+const closeMeanings = {
+    1008: "You were kicked from the game",
+    4000: "Your connection fell too far behind the game",
+    4001: "You could not join that room",
+};
+
+// Sends the player back to the join screen, telling them why the server let them go. Reloading
+// starts the next game from a clean page, so the reason is kept across it.
+const connectionClosed = (event) => {
+    const meaning = closeMeanings[event.code] || "Lost the connection to the server";
+    sessionStorage.setItem("disconnected", event.reason ? meaning + ": " + event.reason : meaning);
+    location.reload();
+};
+
+const attemptConnection = (username, code, password) => {
+    const query = "?username=" + encodeURIComponent(username)
+        + "&code=" + encodeURIComponent(code)
+        + "&password=" + encodeURIComponent(password);
+    fetch("/namecheck" + query, {method: "POST"})
         .then((response) => {
             if (response.ok) {
-                game.socket = new WebSocket("/ws?username=" + encodeURIComponent(username));
+                // Binary is preferred, load the page with ?protocol=json to read messages while debugging
+                const debugging = new URLSearchParams(window.location.search).get("protocol") === "json";
+                game.socket = new WebSocket("/ws" + query, debugging ? ["infiltrate.json"] : ["infiltrate.bin", "infiltrate.json"]);
+                game.socket.binaryType = "arraybuffer";
                 game.socket.onerror = connectionRefused;
                 game.socket.onopen = startGame;
                 game.socket.onmessage = handleMessage;
+                game.socket.onclose = connectionClosed;
             } else {
                 response.text().then((text) => {
                     const error = document.getElementById("error");
@@ -98,6 +188,19 @@ const attemptConnection = (username) => {
                             error.innerHTML = "Please input a username that is appropriate";
                             error.style.display = "block";
                             break;
+                        case "room not found":
+                            error.innerHTML = "No room has that code, it may have closed";
+                            error.style.display = "block";
+                            loadRooms();
+                            break;
+                        case "wrong password":
+                            error.innerHTML = "That password is not right for this room";
+                            error.style.display = "block";
+                            break;
+                        case "room is full":
+                            error.innerHTML = "That room is full, please pick another";
+                            error.style.display = "block";
+                            break;
                         default:
                             console.log("Unknown response: " + text);
                     }
@@ -115,7 +218,58 @@ const onClickPlay = () => {
         error.style.display = "block";
         return;
     }
-    attemptConnection(username);
+    const code = document.getElementById("code-field").value.trim() || document.getElementById("room-select").value;
+    attemptConnection(username, code, document.getElementById("password-field").value);
+}
+
+// Fills the room and map dropdowns from the lobby
+const loadRooms = () => {
+    fetch("/rooms")
+        .then((response) => response.json())
+        .then(({rooms, maps}) => {
+            const roomSelect = document.getElementById("room-select");
+            const previous = roomSelect.value;
+            roomSelect.innerHTML = "";
+            for (const room of rooms) {
+                const option = document.createElement("option");
+                option.value = room.code;
+                option.text = room.code + " (" + room.players + "/" + room.maxPlayers + " playing)" + (room.locked ? " \u{1F512}" : "");
+                option.selected = room.code === previous;
+                roomSelect.add(option);
+            }
+            const mapSelect = document.getElementById("map-select");
+            mapSelect.innerHTML = "";
+            for (const map of maps) {
+                const option = document.createElement("option");
+                option.value = map;
+                option.text = map;
+                mapSelect.add(option);
+            }
+        });
+}
+
+const onClickNewRoom = () => {
+    fetch("/rooms", {
+        method: "POST",
+        body: JSON.stringify({
+            map: document.getElementById("map-select").value,
+            private: document.getElementById("private-field").checked,
+            password: document.getElementById("password-field").value,
+            maxPlayers: parseInt(document.getElementById("max-players-field").value) || 0,
+        }),
+    })
+        .then((response) => {
+            if (!response.ok) throw new Error("could not create room");
+            return response.json();
+        })
+        .then((room) => {
+            document.getElementById("code-field").value = room.code;
+            const error = document.getElementById("error");
+            error.innerHTML = "Your room code is " + room.code + ", share it with your friends";
+            error.style.display = "block";
+            loadRooms();
+        })
+        .catch((err) => console.log(err));
 }
 
 const main = () => {
@@ -127,6 +281,15 @@ const main = () => {
     game.client = drawClient(clientX, clientY)
     game.ui = drawUI()
     document.getElementById("play-button").onclick = onClickPlay;
+    document.getElementById("new-room-button").onclick = onClickNewRoom;
+    const disconnected = sessionStorage.getItem("disconnected");
+    if (disconnected) {
+        sessionStorage.removeItem("disconnected");
+        const error = document.getElementById("error");
+        error.textContent = disconnected;
+        error.style.display = "block";
+    }
+    loadRooms();
 };
 
 const keysDown = {
@@ -138,6 +301,9 @@ const keysDown = {
     "ArrowDown": false,
     "KeyD": false,
     "ArrowRight": false,
+    "ShiftLeft": false,
+    "ShiftRight": false,
+    "KeyC": false,
 };
 onkeydown = onkeyup = (event) => {
     keysDown[event.code] = (event.type === "keydown");
@@ -167,122 +333,100 @@ const getKeyInput = () => {
     return delta;
 }
 
+// The server simulates one input per tick, so send them at the tick rate however the browser schedules us.
+// Time the tab spent in the background is not made up for.
+const sendInputs = () => {
+    const now = performance.now();
+    game.inputsDue = Math.min(game.inputsDue + (now - game.lastInputTime) * game.tickRate / 1000, 5);
+    game.lastInputTime = now;
+    while (game.inputsDue >= 1) {
+        game.inputsDue--;
+        update();
+    }
+};
+
 const update = () => {
-    let delta = getKeyInput();
-    delta.x *= game.moveSpeed; delta.y *= game.moveSpeed;
-    if (delta.x || delta.y) {
-        game.client.rotation = Math.atan2(delta.y, delta.x) + .5*Math.PI;
+    if (game.socket.readyState !== game.socket.OPEN) return;
+    const direction = getKeyInput();
+    const input = {
+        seq: ++game.inputSeq,
+        dirX: direction.x,
+        dirY: direction.y,
+        sprint: keysDown["ShiftLeft"] || keysDown["ShiftRight"],
+        sneak: keysDown["KeyC"], // slow, but guards can't hear it
+    };
+    if (input.dirX || input.dirY) {
+        game.client.rotation = Math.atan2(input.dirY, input.dirX) + .5*Math.PI;
     }
-    collideDelta(delta);
-    game.grid.position.subtract(delta);
-    game.obstacles.position.subtract(delta);
-    game.items.position.subtract(delta);
-    game.players.position.subtract(delta);
-    game.guards.position.subtract(delta);
-    game.clientGlobalPos.x += delta.x;
-    game.clientGlobalPos.y += delta.y;
+    // Predict the move locally, the server's answer arrives later in reconcile()
+    game.pendingInputs.push(input);
+    const next = simulateInput(game.clientGlobalPos, input);
+    moveView(next.x - game.clientGlobalPos.x, next.y - game.clientGlobalPos.y);
 
     const itemId = updateItems();
-    const guardId = detected();
 
-    if (game.socket.readyState !== game.socket.OPEN) return;
-    game.socket.send(JSON.stringify({
+    const request = {
         Requesting: "update",
-        X: game.clientGlobalPos.x,
-        Y: game.clientGlobalPos.y,
-        Rotation: game.client.rotation,
+        Seq: input.seq,
+        DirX: input.dirX,
+        DirY: input.dirY,
+        Sprint: input.sprint,
+        Sneak: input.sneak,
         Interaction: itemId || "",
-        DetectedBy: guardId || "",
-    }));
+        Ack: game.ack,
+    };
+    game.socket.send(game.socket.protocol === "infiltrate.bin" ? protocol.encodeUpdate(request) : JSON.stringify(request));
 };
 
-const collideDelta = (delta) => {
-    const clientR = 25
-    const nextX = clientX + delta.x;
-    const nextY = clientY + delta.y;
-    for (let obstacle of game.obstacles.children) {
-        const obstacleX = obstacle.position.x + game.obstacles.position.x;
-        const obstacleY = obstacle.position.y + game.obstacles.position.y;
-        const distX = Math.abs(clientX - obstacleX);
-        const distY = Math.abs(clientY - obstacleY);
-        const nextDistX = Math.abs(nextX - obstacleX);
-        const nextDistY = Math.abs(nextY - obstacleY);
-
-        if ((distY < ((obstacle.height*.5)+clientR)) && (nextDistX <= (obstacle.width*.5)+clientR)) { // collision on x-axis
-            delta.x = (distX - ((obstacle.width*.5)+clientR))*(delta.x/game.moveSpeed);
-        }
-        if ((distX < ((obstacle.width*.5)+clientR)) && (nextDistY <= ((obstacle.height*.5)+clientR))) { // collision on y-axis
-            delta.y = (distY - ((obstacle.height*.5)+clientR))*(delta.y/game.moveSpeed);
-        }
-    }
-    return delta
-}
+// Moves the camera (and so the client) by delta in global coordinates
+const moveView = (deltaX, deltaY) => {
+    game.grid.position.subtract(deltaX, deltaY);
+    game.obstacles.position.subtract(deltaX, deltaY);
+    game.items.position.subtract(deltaX, deltaY);
+    game.players.position.subtract(deltaX, deltaY);
+    game.guards.position.subtract(deltaX, deltaY);
+    game.clientGlobalPos.x += deltaX;
+    game.clientGlobalPos.y += deltaY;
+};
 
-const detected = () => {
-    for (const guard of game.guards.children) {
-        if (guard.id === "") continue;
-        const detectedId = detectedBy(guard);
-        if (detectedId) return detectedId;
+// Snaps to the server's position for the client, then replays inputs the server has not processed yet
+const reconcile = (self) => {
+    game.pendingInputs = game.pendingInputs.filter((input) => input.seq > self.lastInput);
+    let position = {x: self.x, y: self.y};
+    for (const input of game.pendingInputs) {
+        position = simulateInput(position, input);
     }
-    return "";
-}
+    moveView(position.x - game.clientGlobalPos.x, position.y - game.clientGlobalPos.y);
+};
 
-const detectedBy = (guard) => {
-    if (!guard.children.ids["searchCone"].visible) return "";
-    let x = 0
-    let y = 0
-    if ((guard.rotation).toFixed(4) == (0).toFixed(4)) {
-        // North
-        y = -1
-    } else if ((guard.rotation).toFixed(4) == (Math.PI/4).toFixed(4)) {
-        // NorthEast
-        x = .70710678 // sqrt(1/2)
-        y = -.70710678
-    } else if ((guard.rotation).toFixed(4) == (Math.PI/2).toFixed(4)) {
-        // East
-        x = 1
-    } else if ((guard.rotation).toFixed(4) == (3*Math.PI/4).toFixed(4)) {
-        // SouthEast
-        x = .70710678 
-        y = .70710678
-    } else if ((guard.rotation).toFixed(4) == (Math.PI).toFixed(4)) {
-        // South
-        y = 1
-    } else if ((guard.rotation).toFixed(4) == (5*Math.PI/4).toFixed(4)) {
-        // SouthWest
-        x = -.70710678 
-        y = .70710678
-    } else if ((guard.rotation).toFixed(4) == (3*Math.PI/2).toFixed(4)) {
-        // West
-        x = -1
-    } else if ((guard.rotation).toFixed(4) == (-Math.PI/4).toFixed(4)) {
-        // NorthWest
-        x = -.70710678
-        y = -.70710678
-    } else {
-        console.log("not cardinal " + guard.rotation);
+// Mirrors player.applyNextInput on the server, so predictions match unless something else moved us
+const simulateInput = (position, input) => {
+    const speed = (input.sprint ? game.sprintSpeed : input.sneak ? game.sneakSpeed : game.moveSpeed) / game.tickRate;
+    const deltaX = input.dirX * speed;
+    const deltaY = input.dirY * speed;
+    if (!deltaX && !deltaY) return position;
+    const candidates = [
+        {x: position.x + deltaX, y: position.y + deltaY},
+        {x: position.x + deltaX, y: position.y},
+        {x: position.x, y: position.y + deltaY},
+    ];
+    for (const next of candidates) {
+        if ((next.x !== position.x || next.y !== position.y) && playerFits(next)) return next;
     }
-    const coneLength = 250
-    const guardX = game.guards.position.x + guard.position.x
-    const guardY = game.guards.position.y + guard.position.y
+    return position;
+};
 
-    let xLow = guardX - 70
-    let xHigh = guardX + 70
-    let yLow = guardY - 70
-    let yHigh = guardY + 70
-    if (x) {
-        xLow = Math.min(guardX+(x*coneLength), guardX)
-        xHigh = Math.max(guardX+(x*coneLength), guardX)
-    }
-    if (y) {
-        yLow = Math.min(guardY+(y*coneLength), guardY)
-        yHigh = Math.max(guardY+(y*coneLength), guardY)
+const playerFits = (position) => {
+    const clientR = 25
+    for (const obstacle of game.obstacleData) {
+        const closestX = Math.max(obstacle.x, Math.min(position.x, obstacle.x + obstacle.width));
+        const closestY = Math.max(obstacle.y, Math.min(position.y, obstacle.y + obstacle.height));
+        const distX = position.x - closestX;
+        const distY = position.y - closestY;
+        if (distX*distX + distY*distY < clientR*clientR) return false;
     }
-    if (xLow > clientX || xHigh < clientX) return ""
-    if (yLow > clientY || yHigh < clientY) return ""
-
-    return guard.id
-}
+    return true;
+};
 
 const updateItems = () => {
     for (const item of game.items.children) {
@@ -291,6 +435,9 @@ const updateItems = () => {
                 const id = updateCoin(item);
                 if (id) return id;
                 break;
+            case "terminal":
+                if (keysDown["KeyE"] && updateTerminal(item)) return item.id;
+                break;
             default:
                 console.log("unknown item type " + item.type + ", skipping update");
         }
@@ -310,6 +457,14 @@ const updateCoin = (coin) => {
     return null
 }
 
+// Reports whether the client is within reach of a terminal, as the server checks it
+const updateTerminal = (terminal) => {
+    const reach = 25 + 15 // pickupRadius and terminalRadius on the server
+    const terminalX = terminal.position.x + game.items.position.x
+    const terminalY = terminal.position.y + game.items.position.y
+    return (clientX - terminalX)**2 + (clientY - terminalY)**2 <= reach**2
+}
+
 // Takes a list of data and intended parent and converts x and y for each item to local coordinates
 const globalToLocalCoords = (data, parent) => {
     for (const datum of data) {
@@ -0,0 +1,241 @@
+// The binary protocol, the layout is documented in protocol.go and must stay in step with it,
+// which protocol_test.js checks against messages the server encoded.
+// Decoded messages have the same shape as their JSON counterparts.
+const protocol = (() => {
+    const setSceneMessage = 1;
+    const updateMessage = 2;
+    const removeMessage = 3;
+    const scoreboardMessage = 4;
+    const updateRequestMessage = 16;
+
+    const coordScale = 16;
+    const angleScale = 1024;
+    const dirScale = 1024;
+
+    const playerIdPrefix = "player";
+    const guardIdPrefix = "guard";
+    const itemIdPrefix = "coin";
+
+    // A guard's alert is sent as its index here, and by name in JSON
+    const alertNames = ["patrol", "suspicious", "chase", "investigate", "return"];
+
+    const textEncoder = new TextEncoder();
+    const textDecoder = new TextDecoder();
+
+    // Numbers stay below 2^53, so plain arithmetic is used instead of 32 bit bitwise operators
+    class Writer {
+        constructor() {
+            this.bytes = [];
+        }
+        byte(value) {
+            this.bytes.push(value);
+        }
+        uvarint(value) {
+            while (value >= 0x80) {
+                this.bytes.push((value % 0x80) + 0x80);
+                value = Math.floor(value / 0x80);
+            }
+            this.bytes.push(value);
+        }
+        varint(value) {
+            this.uvarint(value >= 0 ? value * 2 : -value * 2 - 1);
+        }
+        id(id, prefix) {
+            const digits = id.startsWith(prefix) ? id.slice(prefix.length) : "";
+            if (/^(0|[1-9][0-9]*)$/.test(digits) && Number(digits) <= Number.MAX_SAFE_INTEGER / 2) {
+                this.uvarint(Number(digits) * 2);
+                return;
+            }
+            const encoded = textEncoder.encode(id);
+            this.uvarint(encoded.length * 2 + 1);
+            this.bytes.push(...encoded);
+        }
+        finish() {
+            return new Uint8Array(this.bytes).buffer;
+        }
+    }
+
+    class Reader {
+        constructor(buffer) {
+            this.bytes = new Uint8Array(buffer);
+            this.offset = 0;
+        }
+        byte() {
+            if (this.offset >= this.bytes.length) throw new Error("binary message ended early");
+            return this.bytes[this.offset++];
+        }
+        uvarint() {
+            let value = 0;
+            let scale = 1;
+            for (;;) {
+                const b = this.byte();
+                value += (b % 0x80) * scale;
+                if (b < 0x80) return value;
+                scale *= 0x80;
+            }
+        }
+        varint() {
+            const zigzag = this.uvarint();
+            return zigzag % 2 === 0 ? zigzag / 2 : -(zigzag + 1) / 2;
+        }
+        count() {
+            return this.uvarint();
+        }
+        raw(length) {
+            if (this.offset + length > this.bytes.length) throw new Error("binary message ended early");
+            const slice = this.bytes.subarray(this.offset, this.offset + length);
+            this.offset += length;
+            return slice;
+        }
+        string() {
+            return textDecoder.decode(this.raw(this.uvarint()));
+        }
+        id(prefix) {
+            const value = this.uvarint();
+            if (value % 2 === 0) return prefix + (value / 2);
+            return textDecoder.decode(this.raw((value - 1) / 2));
+        }
+        ids(prefix) {
+            const ids = [];
+            for (let i = this.count(); i > 0; i--) ids.push(this.id(prefix));
+            return ids;
+        }
+        coord() {
+            return this.varint() / coordScale;
+        }
+        angle() {
+            return this.varint() / angleScale;
+        }
+    }
+
+    const readPlayer = (r) => ({
+        id: r.id(playerIdPrefix),
+        username: r.string(),
+        x: r.coord(),
+        y: r.coord(),
+        rotation: r.angle(),
+        score: r.varint(),
+        lastInput: r.uvarint(),
+    });
+
+    const readItems = (r) => {
+        const items = [];
+        for (let i = r.count(); i > 0; i--) {
+            items.push({id: r.id(itemIdPrefix), type: r.string(), x: r.coord(), y: r.coord()});
+        }
+        return items;
+    };
+
+    // Reads the fields whose bit is set in the next mask, in order, into entity
+    const readMasked = (r, entity, fields) => {
+        const mask = r.uvarint();
+        for (let i = 0; i < fields.length; i++) {
+            if (Math.floor(mask / 2**i) % 2) {
+                const [name, read] = fields[i];
+                entity[name] = read();
+            }
+        }
+        return entity;
+    };
+
+    const decodeSetScene = (r) => {
+        const flags = r.byte();
+        const scene = {
+            requesting: "setScene",
+            player: {id: "", username: "", x: 0, y: 0, rotation: 0, score: 0, lastInput: 0},
+            obstacles: null,
+            items: null,
+        };
+        if (flags & 1) scene.player = readPlayer(r);
+        if (flags & 2) {
+            scene.obstacles = [];
+            for (let i = r.count(); i > 0; i--) {
+                scene.obstacles.push({x: r.coord(), y: r.coord(), width: r.coord(), height: r.coord(), color: r.string(), stroke: r.string()});
+            }
+        }
+        if (flags & 4) scene.items = readItems(r);
+        if (flags & 8) scene.tickRate = r.uvarint();
+        if (flags & 16) {
+            scene.guardTypes = [];
+            for (let i = r.count(); i > 0; i--) {
+                scene.guardTypes.push({
+                    name: r.string(), speed: r.coord(), turnRate: r.angle(), radius: r.coord(),
+                    killRange: r.coord(), sightRange: r.coord(), sightAngle: r.angle(), hearing: r.coord(),
+                });
+            }
+        }
+        return scene;
+    };
+
+    const decodeUpdate = (r) => {
+        const update = {requesting: "update", tick: r.uvarint(), baseline: r.uvarint(), players: [], guards: []};
+        for (let i = r.count(); i > 0; i--) {
+            update.players.push(readMasked(r, {id: r.id(playerIdPrefix)}, [
+                ["username", () => r.string()],
+                ["x", () => r.coord()],
+                ["y", () => r.coord()],
+                ["rotation", () => r.angle()],
+                ["score", () => r.varint()],
+                ["lastInput", () => r.uvarint()],
+            ]));
+        }
+        for (let i = r.count(); i > 0; i--) {
+            update.guards.push(readMasked(r, {id: r.id(guardIdPrefix)}, [
+                ["x", () => r.coord()],
+                ["y", () => r.coord()],
+                ["rotation", () => r.angle()],
+                ["alert", () => alertNames[r.byte()]],
+                ["type", () => r.string()],
+                ["offline", () => r.byte() !== 0],
+            ]));
+        }
+        update.items = readItems(r);
+        // Left out when empty, as in JSON
+        for (const [field, prefix] of [["removedPlayers", playerIdPrefix], ["removedGuards", guardIdPrefix], ["removedItems", itemIdPrefix]]) {
+            const ids = r.ids(prefix);
+            if (ids.length > 0) update[field] = ids;
+        }
+        return update;
+    };
+
+    const decodeScoreboard = (r) => {
+        const scoreboard = {requesting: "scoreboard", scores: []};
+        for (let i = r.count(); i > 0; i--) {
+            scoreboard.scores.push({username: r.string(), score: r.varint()});
+        }
+        return scoreboard;
+    };
+
+    const decodeRemove = (r) => {
+        const type = r.string();
+        return {requesting: "remove", type, id: r.id(type === "player" ? playerIdPrefix : itemIdPrefix)};
+    };
+
+    return {
+        decode: (buffer) => {
+            const r = new Reader(buffer);
+            switch (r.byte()) {
+                case setSceneMessage:
+                    return decodeSetScene(r);
+                case updateMessage:
+                    return decodeUpdate(r);
+                case removeMessage:
+                    return decodeRemove(r);
+                case scoreboardMessage:
+                    return decodeScoreboard(r);
+            }
+            throw new Error("unknown message type");
+        },
+        encodeUpdate: ({Seq, DirX, DirY, Sprint, Sneak, Interaction, Ack}) => {
+            const w = new Writer();
+            w.byte(updateRequestMessage);
+            w.uvarint(Seq);
+            w.varint(Math.round(DirX * dirScale));
+            w.varint(Math.round(DirY * dirScale));
+            w.byte((Sprint ? 1 : 0) + (Sneak ? 2 : 0));
+            w.id(Interaction, itemIdPrefix);
+            w.uvarint(Ack);
+            return w.finish();
+        },
+    };
+})();
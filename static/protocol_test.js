@@ -0,0 +1,27 @@
+// Checks protocol.js against testdata/protocol.json, the messages as the server encodes them.
+// Run with node --test static/, or go test runs it when node is installed.
+const assert = require("node:assert");
+const fs = require("node:fs");
+const path = require("node:path");
+const test = require("node:test");
+const vm = require("node:vm");
+
+const source = fs.readFileSync(path.join(__dirname, "protocol.js"), "utf8");
+const protocol = vm.runInNewContext(source + "\nprotocol;", {TextEncoder, TextDecoder});
+const fixture = JSON.parse(fs.readFileSync(path.join(__dirname, "..", "testdata", "protocol.json"), "utf8"));
+
+const bufferOf = (base64) => new Uint8Array(Buffer.from(base64, "base64")).buffer;
+
+for (const [name, {binary, json}] of Object.entries(fixture.responses)) {
+    test(`decodes ${name} as its JSON reads`, () => {
+        // Objects from the vm's context have other prototypes, which deepStrictEqual would count
+        const decoded = JSON.parse(JSON.stringify(protocol.decode(bufferOf(binary))));
+        assert.deepStrictEqual(decoded, json);
+    });
+}
+
+fixture.updateRequests.forEach(({binary, json}, i) => {
+    test(`encodes update request ${i} as the server reads it`, () => {
+        assert.deepStrictEqual(Buffer.from(protocol.encodeUpdate(json)).toString("base64"), binary);
+    });
+});
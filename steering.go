@@ -0,0 +1,196 @@
+package main
+
+import "math"
+
+// How many ticks ahead a guard looks for other guards it would run into
+const avoidHorizon = 60
+
+// How soon a collision has to be, in ticks, to be worth straying one full step from the plan
+const avoidWeight = 30
+
+// How far past touching guards start to keep apart
+const separationMargin = 10
+
+// How far a guard can be pushed off the walk it planned before it drops it and is planned for afresh
+const maxDrift = 3 * navCellSize
+
+// How many headings a guard tries either side of the one it wants, evenly up to turning back
+const steerHeadings = 8
+
+// solid reports whether other guards have to steer around g. Cameras are up on the walls.
+func (g *guard) solid() bool {
+	return g.pan == nil
+}
+
+// goesFirst reports whether g has the right of way over other when their paths cross: guards
+// giving chase over those that aren't, then by id
+func (g *guard) goesFirst(other *guard) bool {
+	if (g.Alert == alertChase) != (other.Alert == alertChase) {
+		return g.Alert == alertChase
+	}
+	return g.Id < other.Id
+}
+
+// A body is a solid guard as of the tick a model was made, for planners to keep clear of
+type body struct {
+	id     string
+	at     state
+	radius float32
+}
+
+// bodies lists every solid guard where it stands
+func (h *Hub) bodies() []body {
+	var bodies []body
+	for _, g := range h.guards {
+		if g.solid() {
+			bodies = append(bodies, body{id: g.Id, at: state{x: g.X, y: g.Y}, radius: g.kind.Radius})
+		}
+	}
+	return bodies
+}
+
+// clearOfGuards reports whether g would be clear of every other guard at s
+func (m *model) clearOfGuards(g *guard, s state) bool {
+	for _, b := range m.bodies {
+		if b.id != g.Id && s.distanceTo(b.at) < g.kind.Radius+b.radius {
+			return false
+		}
+	}
+	return true
+}
+
+// nearbyGuards lists the solid guards other than g that it could reach within avoidHorizon
+func (h *Hub) nearbyGuards(g *guard, step float32) []*guard {
+	var nearby []*guard
+	at := state{x: g.X, y: g.Y}
+	for i := range h.guards {
+		other := &h.guards[i]
+		reach := g.kind.Radius + other.kind.Radius + separationMargin + 2*step*avoidHorizon
+		if other != g && other.solid() && at.distanceTo(state{x: other.X, y: other.Y}) < reach {
+			nearby = append(nearby, other)
+		}
+	}
+	return nearby
+}
+
+// steer picks the move g makes this tick, where its plan would have it move by want, and keeps what
+// it would rather do as its intent for other guards to reckon with. Guards close by push it away.
+// Of the moves around that, it takes the one that strays least from it while putting off running
+// into any other guard longest, in the manner of reciprocal velocity obstacles: it reckons on a
+// guard that is walking too doing half the work of getting out of the way, unless that guard goes
+// first. It never moves into an obstacle or further into another guard, and stands still if nothing
+// else will do.
+func (h *Hub) steer(g *guard, want state, m model) state {
+	at := state{x: g.X, y: g.Y}
+	step := m.guardStep(g)
+	nearby := h.nearbyGuards(g, step)
+
+	preferred := want
+	for _, other := range nearby {
+		away := at.minus(state{x: other.X, y: other.Y})
+		distance := away.length()
+		gap := g.kind.Radius + other.kind.Radius + separationMargin
+		if distance > 0 && distance < gap {
+			preferred = preferred.plus(away.times(step * (gap - distance) / gap / distance))
+		}
+	}
+	if speed := preferred.length(); speed > step {
+		preferred = preferred.times(step / speed)
+	}
+	g.intent = preferred
+	speed := preferred.length()
+	if speed == 0 {
+		return state{}
+	}
+	if len(nearby) == 0 {
+		// Nobody to steer around, so the plan's move as long as nothing is in the way
+		if !m.guardFits(at.plus(preferred), g.kind.Radius) {
+			return state{}
+		}
+		return preferred
+	}
+
+	local := m.around(at, g.kind.Radius+step)
+	best, bestPenalty := state{}, float32(math.Inf(1))
+	consider := func(move state) {
+		to := at.plus(move)
+		if !local.guardFits(to, g.kind.Radius) {
+			return
+		}
+		penalty := move.minus(preferred).length()
+		for _, other := range nearby {
+			from := state{x: other.X, y: other.Y}
+			touching := g.kind.Radius + other.kind.Radius
+			if to.distanceTo(from) < touching && to.distanceTo(from) < at.distanceTo(from) {
+				return
+			}
+			var closing state
+			switch {
+			case len(other.actions) == 0:
+				closing = move.minus(other.velocity)
+			case other.goesFirst(g):
+				// It will keep going where it wants, so this guard gets out of its way, backing out
+				// of a doorway if it has to
+				closing = move.minus(other.intent)
+			default:
+				// It is steering too, so it will take half of whatever turn this is
+				closing = move.times(2).minus(g.velocity).minus(other.velocity)
+			}
+			if t := timeToCollision(from.minus(at), closing, touching); t <= avoidHorizon {
+				penalty += avoidWeight * step / max(t, 1)
+			}
+		}
+		if penalty < bestPenalty {
+			best, bestPenalty = move, penalty
+		}
+	}
+	heading := math.Atan2(float64(preferred.y), float64(preferred.x))
+	for k := range steerHeadings + 1 {
+		// Straight on first, then ever wider turns, the same way first so that two guards meeting
+		// head on both keep to the same side
+		for _, side := range []float64{1, -1} {
+			turned := heading + side*float64(k)*math.Pi/steerHeadings
+			direction := state{x: float32(math.Cos(turned)), y: float32(math.Sin(turned))}
+			consider(direction.times(speed))
+			consider(direction.times(speed / 2))
+			if k == 0 || k == steerHeadings {
+				break
+			}
+		}
+	}
+	consider(state{})
+	return best
+}
+
+// around returns m with only the obstacles and restricted areas within reach of at, for testing
+// many places near at against
+func (m model) around(at state, reach float32) model {
+	near := func(rects []obstacle) []obstacle {
+		var kept []obstacle
+		for _, rect := range rects {
+			if circleIntersects(at, reach, rect) {
+				kept = append(kept, rect)
+			}
+		}
+		return kept
+	}
+	m.obstacles, m.restrictedAreas = near(m.obstacles), near(m.restrictedAreas)
+	return m
+}
+
+// timeToCollision returns how many ticks until two circles touching at distance touching meet, one
+// at offset from the other and closing on it at closing per tick. It is infinite if they never do,
+// and 0 if they already touch.
+func timeToCollision(offset, closing state, touching float32) float32 {
+	gap := offset.dot(offset) - touching*touching
+	if gap <= 0 {
+		return 0
+	}
+	speed := closing.dot(closing)
+	toward := offset.dot(closing)
+	discriminant := toward*toward - speed*gap
+	if speed == 0 || toward <= 0 || discriminant < 0 {
+		return float32(math.Inf(1))
+	}
+	return (toward - float32(math.Sqrt(float64(discriminant)))) / speed
+}
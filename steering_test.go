@@ -0,0 +1,102 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// Two guards whose patrols run head on into each other in the open, and two more whose patrols
+// cross through the same doorway from either side
+const steeringMap = `{
+	"obstacles": [
+		{"x": 1700, "y": -800, "width": 370, "height": 20},
+		{"x": 2130, "y": -800, "width": 370, "height": 20}
+	],
+	"items": [],
+	"guards": [
+		{"id": "east", "x": 1800, "y": -400, "rotation": 1.5708, "patrolPoints": [{"x": 1800, "y": -400}, {"x": 2400, "y": -400}]},
+		{"id": "west", "x": 2400, "y": -400, "rotation": -1.5708, "patrolPoints": [{"x": 2400, "y": -400}, {"x": 1800, "y": -400}]},
+		{"id": "north", "x": 2100, "y": -650, "rotation": 0, "patrolPoints": [{"x": 2100, "y": -650}, {"x": 2100, "y": -950}]},
+		{"id": "south", "x": 2100, "y": -950, "rotation": 3.14159, "patrolPoints": [{"x": 2100, "y": -950}, {"x": 2100, "y": -650}]}
+	]
+}`
+
+// A guard whose patrol goes through a doorway a sentry stands in, so it can't steer its way past
+const sentryMap = `{
+	"obstacles": [
+		{"x": 1100, "y": -500, "width": 360, "height": 20},
+		{"x": 1540, "y": -500, "width": 360, "height": 20}
+	],
+	"items": [],
+	"guardTypes": [{"name": "sentry", "speed": 0}],
+	"guards": [
+		{"id": "walker", "x": 1500, "y": -350, "rotation": 3.14159, "patrolPoints": [{"x": 1500, "y": -350}, {"x": 1500, "y": -700}]},
+		{"id": "sentry", "type": "sentry", "x": 1500, "y": -490, "rotation": 0}
+	]
+}`
+
+// checkApart fails t if any two of the guards named overlap
+func checkApart(t *testing.T, sim *simulation, ids ...string) {
+	t.Helper()
+	for i, id := range ids {
+		for _, otherID := range ids[i+1:] {
+			a, b := sim.guard(id), sim.guard(otherID)
+			if distance := (state{x: a.X, y: a.Y}).distanceTo(state{x: b.X, y: b.Y}); distance < a.kind.Radius+b.kind.Radius-0.01 {
+				t.Fatalf("%s and %s overlap at tick %d, %v apart", id, otherID, sim.hub.tick, distance)
+			}
+		}
+	}
+}
+
+func TestGuardsPassEachOtherHeadOn(t *testing.T) {
+	sim := newSimulation(t, steeringMap, 1)
+	east, west := sim.guard("east"), sim.guard("west")
+	eastArrived, westArrived := false, false
+	for range 10 * sim.hub.cfg.TickRate {
+		sim.run(1)
+		checkApart(t, sim, "east", "west")
+		eastArrived = eastArrived || (state{x: east.X, y: east.Y}).distanceTo(state{x: 2400, y: -400}) < goalReachedDistance
+		westArrived = westArrived || (state{x: west.X, y: west.Y}).distanceTo(state{x: 1800, y: -400}) < goalReachedDistance
+	}
+	if !eastArrived || !westArrived {
+		t.Errorf("east got across: %v, west got across: %v, want both past each other", eastArrived, westArrived)
+	}
+}
+
+func TestGuardsTakeTurnsThroughADoorway(t *testing.T) {
+	sim := newSimulation(t, steeringMap, 1)
+	north, south := sim.guard("north"), sim.guard("south")
+	northThrough, southThrough := false, false
+	for range 15 * sim.hub.cfg.TickRate {
+		sim.run(1)
+		checkApart(t, sim, "north", "south")
+		northThrough = northThrough || north.Y < -850
+		southThrough = southThrough || south.Y > -750
+	}
+	if !northThrough || !southThrough {
+		t.Errorf("north got through: %v, south got through: %v, want neither left waiting", northThrough, southThrough)
+	}
+}
+
+func TestGuardBacksOutOfADoorwayASentryBlocks(t *testing.T) {
+	sim := newSimulation(t, sentryMap, 1)
+	g := sim.guard("walker")
+	m := sim.hub.model()
+	backedOut, movedOn := false, false
+	for range 2 * sim.hub.ticksIn(sim.hub.cfg.StuckTime) {
+		from := state{x: g.X, y: g.Y}
+		sim.run(1)
+		if moved := from.distanceTo(state{x: g.X, y: g.Y}); moved > m.guardStep(g)*1.01 {
+			t.Fatalf("walker jumped %v from %v in a tick", moved, from)
+		}
+		checkApart(t, sim, "walker", "sentry")
+		backedOut = backedOut || slices.Equal(g.thinking, []string{"guard", "unstick", "escape"})
+		movedOn = movedOn || (backedOut && g.currentPoint == 0)
+	}
+	if !backedOut {
+		t.Error("walker never backed out of the doorway")
+	}
+	if !movedOn {
+		t.Error("walker never moved on from the patrol point past the doorway")
+	}
+}